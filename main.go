@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/san035/google-drive-upload/pkg/googleupload"
+	"google.golang.org/api/drive/v3"
 
 	"log/slog"
 	"os"
@@ -13,10 +17,44 @@ import (
 const (
 	fileUploadDefault = "send_file.txt"
 	idDiskDefault     = ""
+	idDiskAll         = "all"
+	fileStdin         = "-"
 )
 
+// cliArgs аргументы командной строки вида key=value
+type cliArgs struct {
+	file     string
+	diskID   string
+	parallel int
+	dir      string
+	tar      string
+	include  []string
+	exclude  []string
+	sync     string
+	delete   bool
+	dryRun   bool
+	restore  string
+	fileID   string
+	version  int
+	out      string
+	list     bool
+	json     bool
+	progress string
+	name     string
+	size     int64
+	keygen   bool
+	migrate  bool
+}
+
 func main() {
 	ctx := context.Background()
+	args := getArgs()
+
+	// keygen - пара ключей для шифрования recipient, конфигурация не нужна
+	if args.keygen {
+		keygen(args)
+		return
+	}
 
 	cfg, err := googleupload.LoadConfig()
 	if err != nil {
@@ -24,39 +62,305 @@ func main() {
 		os.Exit(1)
 	}
 
+	// migrate-secrets=true - перешифровать учётные данные и токены схемой из secret_protection
+	if args.migrate {
+		migrated, err := cfg.MigrateSecrets()
+		if err != nil {
+			slog.Error("Ошибка перешифрования секретов", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("секреты перешифрованы", "files", migrated)
+		return
+	}
+
 	driveService, err := googleupload.NewDriveService(ctx, cfg)
 	if err != nil {
 		slog.Error("Ошибка создания сервиса Drive", "error", err)
 		os.Exit(1)
 	}
 
-	file, diskID := getFileAndIDDisk()
+	// restore=name или fileid=id - скачивание копии файла с диска
+	if args.restore != "" || args.fileID != "" {
+		restore(ctx, driveService, args)
+		return
+	}
 
-	if err := driveService.UploadFile(ctx, file, diskID); err != nil {
+	// sync=path - односторонняя синхронизация каталога с папкой диска
+	if args.sync != "" {
+		syncDir(ctx, driveService, args)
+		return
+	}
+
+	// dir=path - загрузка каталога с сохранением иерархии папок
+	if args.dir != "" {
+		uploadDir(ctx, driveService, args)
+		return
+	}
+
+	// iddisk=all или iddisk=a,b - зеркальная загрузка на несколько дисков
+	if args.diskID == idDiskAll || strings.Contains(args.diskID, ",") {
+		mirror(ctx, driveService, args)
+		return
+	}
+
+	opts := []googleupload.UploadOption{googleupload.UseIDDisk(args.diskID)}
+	// progress=bar - полоса прогресса, progress=json - события JSON Lines, оба в stderr: stdout - для результата json=true
+	switch args.progress {
+	case "bar":
+		opts = append(opts, googleupload.UseProgressReporter(googleupload.NewTerminalReporter(os.Stderr)))
+	case "json":
+		opts = append(opts, googleupload.UseProgressReporter(googleupload.NewJSONReporter(os.Stderr)))
+	case "", "log":
+	default:
+		slog.Warn("неверное значение progress", "value", args.progress)
+	}
+
+	var result *googleupload.UploadResult
+	switch {
+	case args.tar != "":
+		// tar=path - каталог одним архивом, exclude - исключаемые файлы, name - имя архива в Drive
+		if args.name != "" {
+			opts = append(opts, googleupload.UseRemoteName(args.name))
+		}
+		result, err = driveService.UploadDirTar(ctx, args.tar, googleupload.TarOptions{Exclude: args.exclude}, opts...)
+	case args.file == fileStdin:
+		// file=- name=db.sql - загрузка из stdin, size - ожидаемый размер для проверки места
+		if args.size > 0 {
+			opts = append(opts, googleupload.UseSizeHint(args.size))
+		}
+		result, err = driveService.UploadReader(ctx, os.Stdin, args.name, opts...)
+	default:
+		if args.name != "" {
+			opts = append(opts, googleupload.UseRemoteName(args.name))
+		}
+		result, err = driveService.Upload(ctx, args.file, opts...)
+	}
+	if err != nil {
 		slog.Error("Ошибка загрузки файла", "error", err)
 		os.Exit(1)
 	}
+	if args.json {
+		printJSON(result)
+	}
+}
+
+// keygen выводит закрытый ключ в формате identity_file, открытый ключ - в комментарии
+func keygen(args cliArgs) {
+	identity, recipient, err := googleupload.GenerateEncryptionKey()
+	if err != nil {
+		slog.Error("Ошибка создания ключа", "error", err)
+		os.Exit(1)
+	}
+	if args.json {
+		printJSON(map[string]string{"identity": identity, "recipient": recipient})
+		return
+	}
+	fmt.Printf("# recipient: %s\n%s\n", recipient, identity)
+}
+
+// printJSON выводит результат в stdout в формате JSON, логи при этом остаются в stderr
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Error("Ошибка вывода JSON", "error", err)
+		os.Exit(1)
+	}
+}
+
+func mirror(ctx context.Context, driveService *googleupload.GoogleDisks, args cliArgs) {
+	var idDisks []string
+	if args.diskID != idDiskAll {
+		idDisks = strings.Split(args.diskID, ",")
+	}
+
+	results, err := driveService.MirrorFile(ctx, args.file, idDisks, args.parallel)
+	if err == nil && args.json {
+		printJSON(results)
+	}
+	if err == nil {
+		err = results.Err()
+	}
+	if err != nil {
+		slog.Error("Ошибка зеркальной загрузки файла", "error", err)
+		os.Exit(1)
+	}
 }
 
-func getFileAndIDDisk() (file string, diskID string) {
+func uploadDir(ctx context.Context, driveService *googleupload.GoogleDisks, args cliArgs) {
+	summary, err := driveService.UploadDir(ctx, args.dir, args.diskID, googleupload.DirUploadOptions{
+		Include:     args.include,
+		Exclude:     args.exclude,
+		Parallelism: args.parallel,
+	})
+	if err != nil {
+		slog.Error("Ошибка загрузки каталога", "error", err)
+		os.Exit(1)
+	}
+	if args.json {
+		printJSON(summary)
+	}
+	for _, failure := range summary.Failures {
+		slog.Error("Ошибка загрузки файла", "file", failure.Path, "error", failure.Err)
+	}
+	if len(summary.Failures) > 0 {
+		os.Exit(1)
+	}
+}
+
+func syncDir(ctx context.Context, driveService *googleupload.GoogleDisks, args cliArgs) {
+	summary, err := driveService.SyncDir(ctx, args.sync, args.diskID, googleupload.SyncOptions{
+		Include:      args.include,
+		Exclude:      args.exclude,
+		DeleteRemote: args.delete,
+		DryRun:       args.dryRun,
+		Parallelism:  args.parallel,
+	})
+	if err != nil {
+		slog.Error("Ошибка синхронизации каталога", "error", err)
+		os.Exit(1)
+	}
+	if args.json {
+		printJSON(summary)
+	} else if args.dryRun {
+		for _, item := range summary.Plan {
+			fmt.Printf("%-6s %s (%s)\n", item.Action, item.Path, googleupload.FormatBytes(item.Size))
+		}
+		fmt.Printf("без изменений: %d\n", summary.Unchanged)
+	}
+	if args.dryRun {
+		return
+	}
+	for _, failure := range summary.Failures {
+		slog.Error("Ошибка синхронизации файла", "file", failure.Path, "error", failure.Err)
+	}
+	if len(summary.Failures) > 0 {
+		os.Exit(1)
+	}
+}
+
+func restore(ctx context.Context, driveService *googleupload.GoogleDisks, args cliArgs) {
+	disk, err := driveService.Disk(args.diskID)
+	if err != nil {
+		slog.Error("Ошибка выбора диска", "error", err)
+		os.Exit(1)
+	}
+
+	// list - вывести сохранённые копии файла вместо скачивания
+	if args.list {
+		copies, err := disk.ListCopies(ctx, args.restore)
+		if err != nil {
+			slog.Error("Ошибка получения списка копий", "error", err)
+			os.Exit(1)
+		}
+		if args.json {
+			printJSON(copies)
+			return
+		}
+		for i, f := range copies {
+			fmt.Printf("%d\t%s\t%s\t%s\n", i, f.ModifiedTime, googleupload.FormatBytes(f.Size), f.Id)
+		}
+		return
+	}
+
+	var file *drive.File
+	if args.fileID != "" {
+		file, err = disk.DownloadFile(ctx, args.fileID, args.out)
+	} else {
+		file, err = disk.DownloadCopy(ctx, args.restore, args.version, args.out)
+	}
+	if err != nil {
+		slog.Error("Ошибка восстановления файла", "error", err)
+		os.Exit(1)
+	}
+	if args.json {
+		printJSON(file)
+	}
+}
+
+// getArgs разбирает аргументы key=value, ведущие "-" у ключа допускаются,
+// аргумент без "=" (например --dry-run) считается флагом со значением true
+func getArgs() cliArgs {
+	var args cliArgs
 	for _, arg := range os.Args[1:] {
-		parts := strings.SplitN(arg, "=", 2)
+		parts := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)
 		if len(parts) != 2 {
-			continue
+			parts = append(parts, "true")
 		}
 		switch strings.ToLower(parts[0]) {
 		case "file":
-			file = parts[1]
+			args.file = parts[1]
 		case "iddisk":
-			diskID = parts[1]
+			args.diskID = parts[1]
+		case "dir":
+			args.dir = parts[1]
+		case "tar":
+			args.tar = parts[1]
+		case "include":
+			args.include = strings.Split(parts[1], ",")
+		case "exclude":
+			args.exclude = strings.Split(parts[1], ",")
+		case "sync":
+			args.sync = parts[1]
+		case "delete":
+			args.delete = parseBool(parts[1])
+		case "dry-run", "dryrun":
+			args.dryRun = parseBool(parts[1])
+		case "json":
+			args.json = parseBool(parts[1])
+		case "progress":
+			args.progress = strings.ToLower(parts[1])
+		case "restore":
+			args.restore = parts[1]
+		case "fileid":
+			args.fileID = parts[1]
+		case "out":
+			args.out = parts[1]
+		case "list":
+			args.list = parseBool(parts[1])
+		case "version":
+			version, err := strconv.Atoi(parts[1])
+			if err != nil {
+				slog.Warn("неверное значение version", "value", parts[1])
+				continue
+			}
+			args.version = version
+		case "keygen":
+			args.keygen = parseBool(parts[1])
+		case "migrate-secrets":
+			args.migrate = parseBool(parts[1])
+		case "name":
+			args.name = parts[1]
+		case "size":
+			size, err := googleupload.ParseByteSize(parts[1])
+			if err != nil {
+				slog.Warn("неверное значение size", "value", parts[1])
+				continue
+			}
+			args.size = size
+		case "parallel":
+			parallel, err := strconv.Atoi(parts[1])
+			if err != nil {
+				slog.Warn("неверное значение parallel", "value", parts[1])
+				continue
+			}
+			args.parallel = parallel
 		}
 	}
 
-	if file == "" {
-		file = fileUploadDefault
+	if args.file == "" {
+		args.file = fileUploadDefault
+	}
+	if args.diskID == "" {
+		args.diskID = idDiskDefault
 	}
-	if diskID == "" {
-		diskID = idDiskDefault
+	return args
+}
+
+func parseBool(value string) bool {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("неверное логическое значение", "value", value)
 	}
-	return file, diskID
+	return b
 }
@@ -0,0 +1,169 @@
+package googleupload
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+// Способы авторизации OAuth для ConfigGoogleDrive.AuthFlow
+const (
+	// AuthFlowLoopback - браузер и локальный HTTP сервер для приёма редиректа (по умолчанию)
+	AuthFlowLoopback = "loopback"
+	// AuthFlowDevice - авторизация устройства: код вводится на https://www.google.com/device с любого устройства.
+	// Требует credentials типа "TVs and Limited Input devices", Google разрешает для него только scope drive.file
+	AuthFlowDevice = "device"
+	// AuthFlowManual - ссылка выводится в консоль, адрес из браузера после редиректа (или код) вставляется в stdin
+	AuthFlowManual = "manual"
+)
+
+// authScopes возвращает scope OAuth для способа авторизации
+func authScopes(authFlow string) []string {
+	if authFlow == AuthFlowDevice {
+		return []string{drive.DriveFileScope}
+	}
+	return []string{drive.DriveScope}
+}
+
+// deviceAuth авторизация устройства (RFC 8628): выводит код и ждёт, пока пользователь введёт его в браузере
+func (gd *GoogleDisk) deviceAuth(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+	l := slog.With("idDisk", gd.cfg.Id)
+	if config.Endpoint.DeviceAuthURL == "" {
+		config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+	}
+
+	da, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса кода устройства: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(gd.authWriter(), "Диск %s: откройте %s и введите код %s\n", gd.cfg.Id, da.VerificationURI, da.UserCode)
+	l.Info("Ожидание авторизации устройства", "url", da.VerificationURI, "code", da.UserCode, "expiry", da.Expiry)
+
+	token, err := config.DeviceAccessToken(ctx, da)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка авторизации устройства: %w", err)
+	}
+	l.Info("Устройство авторизовано")
+	return token, nil
+}
+
+// manualCodeAuth выводит ссылку авторизации и читает из stdin адрес, на который браузер был перенаправлен, или сам код
+func (gd *GoogleDisk) manualCodeAuth(ctx context.Context, authURL, state string, timeout time.Duration) (string, error) {
+	lines := gd.authLines()
+	// Строка, введённая после таймаута предыдущей авторизации, к этой не относится
+	select {
+	case <-lines:
+	default:
+	}
+
+	w := gd.authWriter()
+	_, _ = fmt.Fprintf(w, "Диск %s: откройте ссылку в браузере на любом устройстве:\n%s\n", gd.cfg.Id, authURL)
+	_, _ = fmt.Fprintln(w, "После авторизации браузер откроет недоступную страницу - вставьте её адрес или значение code и нажмите Enter:")
+
+	// Ждём ввод с таймаутом
+	select {
+	case res, ok := <-lines:
+		if !ok {
+			return "", fmt.Errorf("ошибка чтения кода авторизации: %w", io.EOF)
+		}
+		if res.err != nil {
+			return "", fmt.Errorf("ошибка чтения кода авторизации: %w", res.err)
+		}
+		return parseAuthInput(res.line, state)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(timeout):
+		return "", fmt.Errorf("время ожидания авторизации истекло (%s)", timeout)
+	}
+}
+
+// authLine строка ввода авторизации или ошибка чтения
+type authLine struct {
+	line string
+	err  error
+}
+
+// stdinAuthLines строки stdin для авторизации всех дисков: stdin читает одна горутина,
+// иначе горутина, оставшаяся после таймаута, перехватила бы ввод для следующего диска
+var (
+	stdinAuthLinesOnce sync.Once
+	stdinAuthLines     <-chan authLine
+)
+
+// authLines возвращает строки ввода авторизации диска, источник ввода читается одной горутиной
+func (gd *GoogleDisk) authLines() <-chan authLine {
+	if gd.authInput == nil {
+		stdinAuthLinesOnce.Do(func() { stdinAuthLines = readAuthLines(os.Stdin) })
+		return stdinAuthLines
+	}
+	gd.authInputLinesOnce.Do(func() { gd.authInputLines = readAuthLines(gd.authInput) })
+	return gd.authInputLines
+}
+
+// readAuthLines читает r построчно в фоне, после ошибки чтения канал закрывается
+func readAuthLines(r io.Reader) <-chan authLine {
+	lines := make(chan authLine)
+	go func() {
+		defer close(lines)
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if errors.Is(err, io.EOF) && line != "" {
+				err = nil
+			}
+			lines <- authLine{line: line, err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// parseAuthInput извлекает код авторизации из адреса редиректа или возвращает введённый код
+func parseAuthInput(input, state string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", errors.New("код авторизации не введён")
+	}
+	if !strings.Contains(input, "code=") {
+		return input, nil
+	}
+
+	query := input
+	if u, err := url.Parse(input); err == nil && u.RawQuery != "" {
+		query = u.RawQuery
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("неверный адрес редиректа: %w", err)
+	}
+	if values.Get("state") != state {
+		return "", errors.New("неверный state параметр")
+	}
+	code := values.Get("code")
+	if code == "" {
+		return "", errors.New("код не получен")
+	}
+	return code, nil
+}
+
+func (gd *GoogleDisk) authWriter() io.Writer {
+	if gd.authOutput != nil {
+		return gd.authOutput
+	}
+	return os.Stderr
+}
@@ -0,0 +1,334 @@
+package googleupload
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeOAuthServer - httptest замена сервера авторизации Google: обмен кода с PKCE и авторизация устройства
+type fakeOAuthServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	devicePolls int
+}
+
+func newFakeOAuthServer(t *testing.T) *fakeOAuthServer {
+	f := &fakeOAuthServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"device_code":      "device-code",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": "https://example.test/device",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/token", f.handleToken)
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeOAuthServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		if r.PostForm.Get("code") != "good-code" || r.PostForm.Get("code_verifier") == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		if r.PostForm.Get("device_code") != "device-code" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		// Первый опрос - пользователь ещё не ввёл код
+		f.mu.Lock()
+		f.devicePolls++
+		pending := f.devicePolls == 1
+		f.mu.Unlock()
+		if pending {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "authorization_pending"})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  "access-" + r.PostForm.Get("grant_type"),
+		"refresh_token": "refresh-token",
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+func (f *fakeOAuthServer) config(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"https://www.googleapis.com/auth/drive"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       f.URL + "/auth",
+			TokenURL:      f.URL + "/token",
+			DeviceAuthURL: f.URL + "/device",
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// memoryTokenStore хранилище токена в памяти
+type memoryTokenStore struct {
+	mu    sync.Mutex
+	token *oauth2.Token
+	saves int
+}
+
+func (s *memoryTokenStore) Load() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == nil {
+		return nil, io.EOF
+	}
+	return s.token, nil
+}
+
+func (s *memoryTokenStore) Save(token *oauth2.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+	s.saves++
+	return nil
+}
+
+func newAuthDisk(id, flow string) (*GoogleDisk, *memoryTokenStore) {
+	store := &memoryTokenStore{}
+	gd := NewGoogleDisk(&ConfigGoogleDrive{Id: id, AuthFlow: flow}, NewMemoryBackend(0))
+	gd.SetTokenStore(store)
+	gd.authOutput = io.Discard
+	return gd, store
+}
+
+// freeLoopbackAddr возвращает свободный адрес 127.0.0.1:port для сервера авторизации
+func freeLoopbackAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+// authURLParams возвращает параметры ссылки авторизации из текста
+func authURLParams(t *testing.T, text string) url.Values {
+	t.Helper()
+	link := regexp.MustCompile(`https?://\S+/auth\?\S+`).FindString(text)
+	u, err := url.Parse(link)
+	if err != nil || link == "" {
+		t.Fatalf("ссылка авторизации не найдена в %q", text)
+	}
+	return u.Query()
+}
+
+func TestAuthorizeLoopback(t *testing.T) {
+	oauth := newFakeOAuthServer(t)
+	redirect := "http://" + freeLoopbackAddr(t) + "/oauth2/callback"
+
+	m := NewAuthManager()
+	m.Timeout = 5 * time.Second
+	// "Браузер" сразу перенаправляет на callback, как Google после согласия пользователя
+	m.OpenBrowser = func(authURL string) error {
+		params := authURLParams(t, authURL)
+		if params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256" {
+			t.Errorf("ссылка авторизации без PKCE: %s", authURL)
+		}
+		if params.Get("access_type") != "offline" {
+			t.Errorf("ссылка авторизации без access_type=offline: %s", authURL)
+		}
+		go func() {
+			resp, err := http.Get(redirect + "?state=" + url.QueryEscape(params.Get("state")) + "&code=good-code")
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	// Два диска авторизуются через один локальный сервер
+	gd1, store1 := newAuthDisk("1", AuthFlowLoopback)
+	gd2, store2 := newAuthDisk("2", AuthFlowLoopback)
+	results := m.Authorize(context.Background(), []AuthRequest{
+		{Disk: gd1, Config: oauth.config(redirect)},
+		{Disk: gd2, Config: oauth.config(redirect)},
+	})
+
+	for i, store := range []*memoryTokenStore{store1, store2} {
+		if results[i].Err != nil {
+			t.Fatalf("диск %s: %v", results[i].DiskID, results[i].Err)
+		}
+		if results[i].Token.AccessToken != "access-authorization_code" || store.saves != 1 {
+			t.Errorf("диск %s: токен %v, сохранений %d", results[i].DiskID, results[i].Token, store.saves)
+		}
+	}
+}
+
+func TestAuthorizeLoopbackDenied(t *testing.T) {
+	oauth := newFakeOAuthServer(t)
+	redirect := "http://" + freeLoopbackAddr(t) + "/oauth2/callback"
+
+	m := NewAuthManager()
+	m.Timeout = 5 * time.Second
+	m.OpenBrowser = func(authURL string) error {
+		state := authURLParams(t, authURL).Get("state")
+		go func() {
+			// Чужой state отклоняется и не завершает ожидание
+			if resp, err := http.Get(redirect + "?state=other&code=good-code"); err == nil {
+				if resp.StatusCode != http.StatusBadRequest {
+					t.Errorf("callback с чужим state: %s", resp.Status)
+				}
+				_ = resp.Body.Close()
+			}
+			if resp, err := http.Get(redirect + "?state=" + url.QueryEscape(state) + "&error=access_denied"); err == nil {
+				_ = resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	gd, store := newAuthDisk("1", AuthFlowLoopback)
+	results := m.Authorize(context.Background(), []AuthRequest{{Disk: gd, Config: oauth.config(redirect)}})
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "access_denied") {
+		t.Errorf("ожидалась ошибка отказа в авторизации, получено %v", results[0].Err)
+	}
+	if store.saves != 0 {
+		t.Error("токен сохранён после отказа в авторизации")
+	}
+}
+
+func TestAuthorizeDevice(t *testing.T) {
+	oauth := newFakeOAuthServer(t)
+	gd, store := newAuthDisk("1", AuthFlowDevice)
+	var out strings.Builder
+	gd.authOutput = &out
+
+	results := NewAuthManager().Authorize(context.Background(), []AuthRequest{{Disk: gd, Config: oauth.config("")}})
+	if results[0].Err != nil {
+		t.Fatal(results[0].Err)
+	}
+	if !strings.Contains(out.String(), "ABCD-EFGH") || !strings.Contains(out.String(), "https://example.test/device") {
+		t.Errorf("код устройства не выведен: %q", out.String())
+	}
+	if results[0].Token.AccessToken != "access-urn:ietf:params:oauth:grant-type:device_code" || store.saves != 1 {
+		t.Errorf("токен %v, сохранений %d", results[0].Token, store.saves)
+	}
+	if oauth.devicePolls != 2 {
+		t.Errorf("опросов сервера: %d, ожидалось 2 (authorization_pending, затем токен)", oauth.devicePolls)
+	}
+}
+
+// redirectTyper изображает пользователя: по ссылке авторизации из вывода вставляет адрес редиректа во ввод
+type redirectTyper struct {
+	t     *testing.T
+	input *io.PipeWriter
+}
+
+func (r *redirectTyper) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), "/auth?") {
+		state := authURLParams(r.t, string(p)).Get("state")
+		go func() {
+			_, _ = io.WriteString(r.input, "http://localhost:8080/oauth2/callback?state="+url.QueryEscape(state)+"&code=good-code&scope=drive\n")
+		}()
+	}
+	return len(p), nil
+}
+
+func TestAuthorizeManual(t *testing.T) {
+	oauth := newFakeOAuthServer(t)
+	input, typed := io.Pipe()
+	t.Cleanup(func() { _ = typed.Close() })
+
+	gd, store := newAuthDisk("1", AuthFlowManual)
+	gd.authInput = input
+	gd.authOutput = &redirectTyper{t: t, input: typed}
+
+	results := NewAuthManager().Authorize(context.Background(), []AuthRequest{{Disk: gd, Config: oauth.config("http://localhost:8080/oauth2/callback")}})
+	if results[0].Err != nil {
+		t.Fatal(results[0].Err)
+	}
+	if results[0].Token.AccessToken != "access-authorization_code" || store.saves != 1 {
+		t.Errorf("токен %v, сохранений %d", results[0].Token, store.saves)
+	}
+}
+
+func TestAuthorizeManualTimeoutKeepsInput(t *testing.T) {
+	oauth := newFakeOAuthServer(t)
+	input, typed := io.Pipe()
+	t.Cleanup(func() { _ = typed.Close() })
+
+	gd, _ := newAuthDisk("1", AuthFlowManual)
+	gd.authInput = input
+	config := oauth.config("http://localhost:8080/oauth2/callback")
+
+	m := NewAuthManager()
+	m.Timeout = 50 * time.Millisecond
+	results := m.Authorize(context.Background(), []AuthRequest{{Disk: gd, Config: config}})
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "время ожидания") {
+		t.Fatalf("ожидался таймаут, получено %v", results[0].Err)
+	}
+
+	// Ввод для повторной авторизации не должна перехватить горутина чтения, оставшаяся от первой
+	gd.authOutput = &redirectTyper{t: t, input: typed}
+	m.Timeout = 5 * time.Second
+	results = m.Authorize(context.Background(), []AuthRequest{{Disk: gd, Config: config}})
+	if results[0].Err != nil {
+		t.Fatalf("повторная авторизация: %v", results[0].Err)
+	}
+}
+
+func TestParseAuthInput(t *testing.T) {
+	tests := []struct {
+		input, want string
+		wantErr     bool
+	}{
+		{input: "4/0Abc-code\n", want: "4/0Abc-code"},
+		{input: "http://localhost:8080/oauth2/callback?state=s1&code=c1&scope=x", want: "c1"},
+		{input: "state=s1&code=c2", want: "c2"},
+		{input: "http://localhost:8080/oauth2/callback?state=other&code=c1", wantErr: true},
+		{input: "http://localhost:8080/oauth2/callback?state=s1&code=", wantErr: true},
+		{input: "  \n", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAuthInput(tt.input, "s1")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAuthInput(%q) = %q, %v", tt.input, got, err)
+		}
+	}
+}
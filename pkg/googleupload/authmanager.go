@@ -0,0 +1,278 @@
+package googleupload
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// authTimeoutDefault - время ожидания авторизации одного диска
+const authTimeoutDefault = 5 * time.Minute
+
+// AuthRequest запрос авторизации диска
+type AuthRequest struct {
+	Disk   *GoogleDisk
+	Config *oauth2.Config
+}
+
+// AuthResult результат авторизации диска
+type AuthResult struct {
+	DiskID string
+	Token  *oauth2.Token
+	Err    error
+}
+
+// callbackResult код авторизации или ошибка, пришедшие на callback
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// AuthManager авторизует несколько дисков за один запуск.
+// Все loopback-авторизации используют один локальный сервер со своим mux,
+// запросы различаются по случайному state, код защищён PKCE
+type AuthManager struct {
+	// Timeout - время ожидания авторизации одного диска
+	Timeout time.Duration
+	// OpenBrowser открывает ссылку авторизации loopback, по умолчанию браузер системы
+	OpenBrowser func(url string) error
+
+	mu       sync.Mutex
+	pending  map[string]chan callbackResult
+	server   *http.Server
+	addr     string
+	serveErr error
+}
+
+// NewAuthManager создаёт менеджер авторизации
+func NewAuthManager() *AuthManager {
+	return &AuthManager{
+		Timeout:     authTimeoutDefault,
+		OpenBrowser: openBrowser,
+		pending:     make(map[string]chan callbackResult),
+	}
+}
+
+// Authorize по очереди авторизует диски способом из их AuthFlow и сохраняет полученные токены.
+// Ошибка одного диска не прерывает авторизацию остальных
+func (m *AuthManager) Authorize(ctx context.Context, requests []AuthRequest) []AuthResult {
+	defer m.shutdown()
+
+	results := make([]AuthResult, 0, len(requests))
+	var succeeded, failed []string
+	for _, req := range requests {
+		l := slog.With("idDisk", req.Disk.cfg.Id)
+
+		token, err := m.authorizeDisk(ctx, req)
+		if err == nil && req.Disk.tokenStore != nil {
+			if saveErr := req.Disk.tokenStore.Save(token); saveErr != nil {
+				l.Warn("Не удалось сохранить токен", "error", saveErr)
+			}
+		}
+
+		if err != nil {
+			l.Error("Ошибка авторизации диска", "error", err)
+			failed = append(failed, req.Disk.cfg.Id)
+		} else {
+			l.Info("Диск авторизован")
+			succeeded = append(succeeded, req.Disk.cfg.Id)
+		}
+		results = append(results, AuthResult{DiskID: req.Disk.cfg.Id, Token: token, Err: err})
+	}
+
+	slog.Info("Авторизация дисков завершена", "succeeded", succeeded, "failed", failed)
+	return results
+}
+
+// authorizeDisk получает новый токен для одного диска
+func (m *AuthManager) authorizeDisk(ctx context.Context, req AuthRequest) (*oauth2.Token, error) {
+	gd, config := req.Disk, req.Config
+	if gd.cfg.AuthFlow == AuthFlowDevice {
+		return gd.deviceAuth(ctx, config)
+	}
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+
+	var code string
+	if gd.cfg.AuthFlow == AuthFlowManual {
+		code, err = gd.manualCodeAuth(ctx, authURL, state, m.Timeout)
+	} else {
+		code, err = m.loopbackCode(ctx, gd, config, authURL, state)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Обмен кода на токен
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка обмена кода на токен: %w", err)
+	}
+	return token, nil
+}
+
+// loopbackCode открывает браузер и ждёт код авторизации на локальном сервере
+func (m *AuthManager) loopbackCode(ctx context.Context, gd *GoogleDisk, config *oauth2.Config, authURL, state string) (string, error) {
+	l := slog.With("idDisk", gd.cfg.Id)
+
+	// Извлекаем хост и порт из config.RedirectURL
+	redirectURL, err := url.Parse(config.RedirectURL)
+	if err != nil {
+		return "", err
+	}
+	if err := m.listen(redirectURL.Host, redirectURL.Path); err != nil {
+		return "", err
+	}
+
+	ch := make(chan callbackResult, 1)
+	m.mu.Lock()
+	m.pending[state] = ch
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.pending, state)
+		m.mu.Unlock()
+	}()
+
+	// Открываем браузер с ссылкой авторизации
+	l.Info("Открываю браузер для авторизации", "url", authURL)
+	open := m.OpenBrowser
+	if open == nil {
+		open = openBrowser
+	}
+	if err := open(authURL); err != nil {
+		l.Warn("Не удалось открыть браузер, скопируйте ссылку вручную", "url", authURL)
+	}
+
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = authTimeoutDefault
+	}
+
+	select {
+	case res := <-ch:
+		if res.err == nil {
+			l.Info("Получен код авторизации")
+		}
+		return res.code, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(timeout):
+		return "", fmt.Errorf("время ожидания авторизации истекло (%s)", timeout)
+	}
+}
+
+// listen запускает локальный сервер для приёма редиректов, если он ещё не запущен
+func (m *AuthManager) listen(addr, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.server != nil {
+		if m.addr != addr {
+			return fmt.Errorf("сервер авторизации уже запущен на %s, адрес %s не поддерживается", m.addr, addr)
+		}
+		return m.serveErr
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("ошибка запуска сервера авторизации %s: %w", addr, err)
+	}
+
+	// Свой mux, чтобы не регистрировать обработчик в http.DefaultServeMux
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, m.handleCallback)
+
+	m.addr = addr
+	m.server = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		slog.Info("Ожидание авторизации", "address", addr)
+		if err := m.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Ошибка HTTP сервера", "error", err)
+			m.mu.Lock()
+			m.serveErr = err
+			m.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// handleCallback передаёт код авторизации ожидающему запросу с тем же state
+func (m *AuthManager) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	m.mu.Lock()
+	ch, ok := m.pending[query.Get("state")]
+	if ok {
+		// Код по state принимается один раз
+		delete(m.pending, query.Get("state"))
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "Неверный state параметр", http.StatusBadRequest)
+		return
+	}
+
+	if authErr := query.Get("error"); authErr != "" {
+		ch <- callbackResult{err: fmt.Errorf("авторизация отклонена: %s", authErr)}
+		http.Error(w, "Авторизация отклонена", http.StatusBadRequest)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		ch <- callbackResult{err: errors.New("код не получен")}
+		http.Error(w, "Код не получен", http.StatusBadRequest)
+		return
+	}
+
+	ch <- callbackResult{code: code}
+
+	// Возвращаем страницу успеха
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = fmt.Fprint(w, `
+			<!DOCTYPE html>
+			<html>
+			<body>
+			Authorization successful
+			</body>
+			</html>
+		`)
+}
+
+// shutdown останавливает локальный сервер
+func (m *AuthManager) shutdown() {
+	m.mu.Lock()
+	server := m.server
+	m.server = nil
+	m.mu.Unlock()
+
+	if server != nil {
+		_ = server.Shutdown(context.Background())
+	}
+}
+
+// randomState возвращает случайное значение state для защиты от CSRF
+func randomState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
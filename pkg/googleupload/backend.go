@@ -0,0 +1,238 @@
+package googleupload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// DriveBackend узкий интерфейс к хранилищу, от которого зависит GoogleDisk.
+// Реализации: driveBackend поверх Drive API и MemoryBackend для тестов без аккаунта Google
+type DriveBackend interface {
+	// FindFiles возвращает файлы с именем name в папке folderID (пустой folderID - корень диска),
+	// не находящиеся в корзине, отсортированные по modifiedTime от старых к новым
+	FindFiles(ctx context.Context, folderID, name string) ([]*drive.File, error)
+	// FindFilesByPrefix возвращает файлы (не папки) в папке folderID, имя которых может начинаться с prefix,
+	// отсортированные по modifiedTime от старых к новым. Drive сравнивает начало слов имени,
+	// поэтому результат нужно дополнительно проверить; пустой prefix - все файлы папки
+	FindFilesByPrefix(ctx context.Context, folderID, prefix string) ([]*drive.File, error)
+	// ListTrash возвращает файлы в корзине, отсортированные по trashedTime от старых к новым
+	ListTrash(ctx context.Context) ([]*drive.File, error)
+	// DeleteFile безвозвратно удаляет файл
+	DeleteFile(ctx context.Context, fileID string) error
+	// CreateFile создаёт файл с метаданными meta и содержимым media
+	CreateFile(ctx context.Context, meta *drive.File, media io.Reader, opts ...googleapi.MediaOption) (*drive.File, error)
+	// GetStorageQuota возвращает квоту хранилища
+	GetStorageQuota(ctx context.Context) (*StorageQuota, error)
+	// FindFolder возвращает папку name в папке parentID (пустой parentID - корень диска), nil - папки нет
+	FindFolder(ctx context.Context, parentID, name string) (*drive.File, error)
+	// CreateFolder создаёт папку name в папке parentID
+	CreateFolder(ctx context.Context, parentID, name string) (*drive.File, error)
+	// ListFolder возвращает все файлы и папки в папке folderID, не находящиеся в корзине,
+	// с размером и md5Checksum, отсортированные по modifiedTime от старых к новым
+	ListFolder(ctx context.Context, folderID string) ([]*drive.File, error)
+	// UpdateFile заменяет содержимое файла, ID и ссылки на файл сохраняются
+	UpdateFile(ctx context.Context, fileID string, media io.Reader) (*drive.File, error)
+	// TrashFile перемещает файл в корзину
+	TrashFile(ctx context.Context, fileID string) error
+	// SetAppProperties добавляет свойства приложения к файлу, остальные свойства сохраняются
+	SetAppProperties(ctx context.Context, fileID string, props map[string]string) error
+	// GetFile возвращает метаданные файла с размером, md5Checksum и appProperties
+	GetFile(ctx context.Context, fileID string) (*drive.File, error)
+	// OpenFile открывает содержимое файла для чтения начиная с байта offset
+	OpenFile(ctx context.Context, fileID string, offset int64) (io.ReadCloser, error)
+}
+
+// uploadFields - поля ответа на создание файла для проверки целостности и результата загрузки
+const uploadFields = "id, name, size, md5Checksum, sha256Checksum, webViewLink"
+
+// FolderMimeType - MIME тип папки Google Drive
+const FolderMimeType = "application/vnd.google-apps.folder"
+
+// driveBackend реализация DriveBackend через Google Drive API
+type driveBackend struct {
+	srv *drive.Service
+}
+
+// NewDriveBackend создаёт DriveBackend поверх сервиса Drive API
+func NewDriveBackend(srv *drive.Service) DriveBackend {
+	return &driveBackend{srv: srv}
+}
+
+func (b *driveBackend) FindFiles(ctx context.Context, folderID, name string) ([]*drive.File, error) {
+	var query string
+	if folderID != "" {
+		query = fmt.Sprintf("'%s' in parents and name = '%s' and trashed = false", folderID, escapeQuery(name))
+	} else {
+		// Если FolderID пустой, ищем файлы в корне диска (без родителя)
+		query = fmt.Sprintf("name = '%s' and trashed = false and 'root' in parents", escapeQuery(name))
+	}
+
+	var result []*drive.File
+	err := b.srv.Files.List().Q(query).
+		Fields("nextPageToken, files(id, name, size, modifiedTime)").
+		OrderBy("modifiedTime asc").PageSize(1000).Context(ctx).
+		Pages(ctx, func(files *drive.FileList) error {
+			result = append(result, files.Files...)
+			return nil
+		})
+	if err != nil {
+		// Если ошибка "Not found" (404), это нормально - просто нет файлов
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == 404 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *driveBackend) FindFilesByPrefix(ctx context.Context, folderID, prefix string) ([]*drive.File, error) {
+	if folderID == "" {
+		folderID = "root"
+	}
+	query := fmt.Sprintf("'%s' in parents and mimeType != '%s' and trashed = false", folderID, FolderMimeType)
+	if prefix != "" {
+		query += fmt.Sprintf(" and name contains '%s'", escapeQuery(prefix))
+	}
+
+	var result []*drive.File
+	err := b.srv.Files.List().Q(query).
+		Fields("nextPageToken, files(id, name, size, modifiedTime)").
+		OrderBy("modifiedTime asc").PageSize(1000).Context(ctx).
+		Pages(ctx, func(files *drive.FileList) error {
+			result = append(result, files.Files...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *driveBackend) ListTrash(ctx context.Context) ([]*drive.File, error) {
+	query := "'me' in owners and trashed = true"
+
+	var result []*drive.File
+	err := b.srv.Files.List().Q(query).
+		Fields("nextPageToken, files(id, name, size, trashedTime, createdTime)").
+		OrderBy("trashedTime asc").PageSize(1000).Context(ctx).
+		Pages(ctx, func(files *drive.FileList) error {
+			result = append(result, files.Files...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *driveBackend) DeleteFile(ctx context.Context, fileID string) error {
+	return b.srv.Files.Delete(fileID).Context(ctx).Do()
+}
+
+func (b *driveBackend) CreateFile(ctx context.Context, meta *drive.File, media io.Reader, opts ...googleapi.MediaOption) (*drive.File, error) {
+	return b.srv.Files.Create(meta).Media(media, opts...).Fields(uploadFields).Context(ctx).Do()
+}
+
+func (b *driveBackend) GetStorageQuota(ctx context.Context) (*StorageQuota, error) {
+	about, err := b.srv.About.Get().Fields("storageQuota").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	// Для аккаунтов без ограничения limit не возвращается
+	return newStorageQuota(about.StorageQuota.Limit, about.StorageQuota.Usage, about.StorageQuota.UsageInDriveTrash), nil
+}
+
+func (b *driveBackend) FindFolder(ctx context.Context, parentID, name string) (*drive.File, error) {
+	if parentID == "" {
+		parentID = "root"
+	}
+	query := fmt.Sprintf("'%s' in parents and name = '%s' and mimeType = '%s' and trashed = false",
+		parentID, escapeQuery(name), FolderMimeType)
+
+	files, err := b.srv.Files.List().Q(query).
+		Fields("files(id, name)").OrderBy("createdTime").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if len(files.Files) == 0 {
+		return nil, nil
+	}
+	return files.Files[0], nil
+}
+
+func (b *driveBackend) CreateFolder(ctx context.Context, parentID, name string) (*drive.File, error) {
+	folder := newDriveFile(parentID, name)
+	folder.MimeType = FolderMimeType
+	return b.srv.Files.Create(folder).Fields("id, name").Context(ctx).Do()
+}
+
+func (b *driveBackend) ListFolder(ctx context.Context, folderID string) ([]*drive.File, error) {
+	if folderID == "" {
+		folderID = "root"
+	}
+	query := fmt.Sprintf("'%s' in parents and trashed = false", folderID)
+
+	var result []*drive.File
+	err := b.srv.Files.List().Q(query).
+		Fields("nextPageToken, files(id, name, size, md5Checksum, mimeType, modifiedTime)").
+		OrderBy("modifiedTime asc").PageSize(1000).Context(ctx).
+		Pages(ctx, func(files *drive.FileList) error {
+			result = append(result, files.Files...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (b *driveBackend) UpdateFile(ctx context.Context, fileID string, media io.Reader) (*drive.File, error) {
+	return b.srv.Files.Update(fileID, &drive.File{}).Media(media).Fields(uploadFields).Context(ctx).Do()
+}
+
+func (b *driveBackend) TrashFile(ctx context.Context, fileID string) error {
+	_, err := b.srv.Files.Update(fileID, &drive.File{Trashed: true}).Fields("id").Context(ctx).Do()
+	return err
+}
+
+func (b *driveBackend) SetAppProperties(ctx context.Context, fileID string, props map[string]string) error {
+	_, err := b.srv.Files.Update(fileID, &drive.File{AppProperties: props}).Fields("id").Context(ctx).Do()
+	return err
+}
+
+func (b *driveBackend) GetFile(ctx context.Context, fileID string) (*drive.File, error) {
+	return b.srv.Files.Get(fileID).
+		Fields("id, name, size, md5Checksum, mimeType, modifiedTime, appProperties").Context(ctx).Do()
+}
+
+func (b *driveBackend) OpenFile(ctx context.Context, fileID string, offset int64) (io.ReadCloser, error) {
+	call := b.srv.Files.Get(fileID).Context(ctx)
+	if offset > 0 {
+		call.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := call.Download()
+	if err != nil {
+		return nil, err
+	}
+
+	// Сервер может проигнорировать Range и вернуть файл целиком
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			deferClose("ошибка закрытия ответа", resp.Body.Close)
+			return nil, fmt.Errorf("ошибка пропуска загруженной части: %w", err)
+		}
+	}
+	return resp.Body, nil
+}
+
+// escapeQuery экранирует строку для запроса Files.List
+func escapeQuery(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
@@ -0,0 +1,102 @@
+package googleupload
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pagedHandler отвечает на список файлов страницами по pageSize файлов, следующая страница - по nextPageToken
+func pagedHandler(total, pageSize int) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		if token := r.URL.Query().Get("pageToken"); token != "" {
+			if _, err := fmt.Sscan(token, &start); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad pageToken"})
+				return
+			}
+		}
+		end := min(start+pageSize, total)
+		files := make([]map[string]any, 0, end-start)
+		for i := start; i < end; i++ {
+			files = append(files, map[string]any{"id": fmt.Sprintf("file-%d", i), "name": "db.sql", "size": "10"})
+		}
+		page := map[string]any{"files": files}
+		if end < total {
+			page["nextPageToken"] = fmt.Sprint(end)
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+func TestDriveBackendFollowsPages(t *testing.T) {
+	ctx := context.Background()
+	for name, list := range map[string]func(DriveBackend) (int, error){
+		"FindFiles": func(b DriveBackend) (int, error) {
+			files, err := b.FindFiles(ctx, "folder", "db.sql")
+			return len(files), err
+		},
+		"ListTrash": func(b DriveBackend) (int, error) {
+			files, err := b.ListTrash(ctx)
+			return len(files), err
+		},
+	} {
+		d := newScriptedDrive(t, pagedHandler(250, 100))
+		var sleeps []time.Duration
+		n, err := list(d.backend(t, &sleeps))
+		if err != nil || n != 250 {
+			t.Errorf("%s: файлов %d из 250: %v", name, n, err)
+		}
+		if len(d.Requests()) != 3 {
+			t.Errorf("%s: запросов %d, ожидалось 3 страницы", name, len(d.Requests()))
+		}
+	}
+}
+
+func TestDriveBackendUnlimitedQuota(t *testing.T) {
+	// Для аккаунтов без ограничения Drive не возвращает limit
+	d := newScriptedDrive(t, func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"storageQuota": map[string]string{"usage": "5000", "usageInDriveTrash": "100"}})
+	})
+	var sleeps []time.Duration
+	quota, err := d.backend(t, &sleeps).GetStorageQuota(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !quota.Unlimited() || quota.FreeBytes != math.MaxInt64 || quota.UsedBytes != 5000 || quota.UsedInTrash != 100 {
+		t.Errorf("квота %+v", quota)
+	}
+}
+
+func TestMemoryBackendQuota(t *testing.T) {
+	ctx := context.Background()
+	limited := NewMemoryBackend(100)
+	limited.AddFile("", "db.sql", make([]byte, 30))
+	if quota, _ := limited.GetStorageQuota(ctx); quota.Unlimited() || quota.TotalBytes != 100 || quota.FreeBytes != 70 {
+		t.Errorf("квота с ограничением %+v", quota)
+	}
+
+	// Без ограничения загрузка с проверкой места проходит, как и сама запись в хранилище
+	unlimited := NewMemoryBackend(0)
+	unlimited.AddFile("", "db.sql", make([]byte, 30))
+	quota, _ := unlimited.GetStorageQuota(ctx)
+	if !quota.Unlimited() || quota.FreeBytes != math.MaxInt64 || quota.UsedBytes != 30 {
+		t.Errorf("квота без ограничения %+v", quota)
+	}
+	filename := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(filename, make([]byte, 1<<20), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gds, err := NewGoogleDisks(NewGoogleDisk(&ConfigGoogleDrive{Id: "d", UploadCopiesCount: 1, Enable: true}, unlimited))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gds.Upload(ctx, filename); err != nil {
+		t.Errorf("загрузка на хранилище без ограничения: %v", err)
+	}
+}
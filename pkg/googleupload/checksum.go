@@ -0,0 +1,114 @@
+package googleupload
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Checksums контрольные суммы отправленного файла в шестнадцатеричном виде
+type Checksums struct {
+	MD5    string `json:"md5"`
+	SHA256 string `json:"sha256,omitempty"` // Только при verify_sha256
+}
+
+// IntegrityError - контрольная сумма файла в Drive не совпала с отправленными данными.
+// Файл с неверным содержимым к этому моменту уже удалён с диска
+type IntegrityError struct {
+	DiskID    string
+	FileID    string
+	Name      string
+	Algorithm string // md5 или sha256
+	Local     string
+	Remote    string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("disk %s: %s файла %s (%s) не совпадает: отправлено %s, в Drive %s",
+		e.DiskID, e.Algorithm, e.Name, e.FileID, e.Local, e.Remote)
+}
+
+// checksummer считает контрольные суммы данных по мере отправки
+type checksummer struct {
+	md5     hash.Hash
+	sha256  hash.Hash
+	written int64
+}
+
+func newChecksummer(withSHA256 bool) *checksummer {
+	c := &checksummer{md5: md5.New()}
+	if withSHA256 {
+		c.sha256 = sha256.New()
+	}
+	return c
+}
+
+func (c *checksummer) Write(p []byte) (int, error) {
+	c.md5.Write(p)
+	if c.sha256 != nil {
+		c.sha256.Write(p)
+	}
+	c.written += int64(len(p))
+	return len(p), nil
+}
+
+// catchUp дочитывает из r данные до offset, которые ещё не попали в контрольные суммы.
+// Нужен для загрузки по частям, где файл читается через ReadAt, в том числе после перезапуска
+func (c *checksummer) catchUp(r io.ReaderAt, offset int64) error {
+	if offset <= c.written {
+		return nil
+	}
+	if _, err := io.Copy(c, io.NewSectionReader(r, c.written, offset-c.written)); err != nil {
+		return fmt.Errorf("ошибка чтения файла для контрольной суммы: %w", err)
+	}
+	return nil
+}
+
+// Sum возвращает посчитанные контрольные суммы
+func (c *checksummer) Sum() *Checksums {
+	sums := &Checksums{MD5: hex.EncodeToString(c.md5.Sum(nil))}
+	if c.sha256 != nil {
+		sums.SHA256 = hex.EncodeToString(c.sha256.Sum(nil))
+	}
+	return sums
+}
+
+// verifyUpload сравнивает контрольные суммы загруженного файла с посчитанными при отправке.
+// При несовпадении удаляет файл с диска и возвращает *IntegrityError
+func (gd *GoogleDisk) verifyUpload(ctx context.Context, driveFile *drive.File, sums *Checksums) error {
+	l := slog.With("file", driveFile.Name, "fileId", driveFile.Id, "idDisk", gd.cfg.Id)
+
+	mismatch := func(algorithm, local, remote string) error {
+		if err := gd.backend.DeleteFile(ctx, driveFile.Id); err != nil {
+			l.Error("ошибка удаления файла с неверной контрольной суммой", "error", err)
+		}
+		return &IntegrityError{
+			DiskID:    gd.cfg.Id,
+			FileID:    driveFile.Id,
+			Name:      driveFile.Name,
+			Algorithm: algorithm,
+			Local:     local,
+			Remote:    remote,
+		}
+	}
+
+	if driveFile.Md5Checksum == "" {
+		l.Warn("Drive не вернул md5Checksum, проверка целостности пропущена")
+	} else if !strings.EqualFold(driveFile.Md5Checksum, sums.MD5) {
+		return mismatch("md5", sums.MD5, driveFile.Md5Checksum)
+	}
+
+	// sha256Checksum заполняется не для всех файлов, пустое значение не считается ошибкой
+	if sums.SHA256 != "" && driveFile.Sha256Checksum != "" && !strings.EqualFold(driveFile.Sha256Checksum, sums.SHA256) {
+		return mismatch("sha256", sums.SHA256, driveFile.Sha256Checksum)
+	}
+	return nil
+}
@@ -3,8 +3,13 @@ package googleupload
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
 
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
 )
@@ -12,18 +17,123 @@ import (
 type GoogleDisks struct {
 	ListGoogleDisk    []*GoogleDisk
 	GoogleDiskDefault *GoogleDisk
+	SelectPolicy      SelectPolicy // Политика выбора диска, если idDisk не указан
+
+	roundRobin atomic.Uint64
 }
 
 type GoogleDisk struct {
-	Srv *drive.Service
-	cfg *ConfigGoogleDrive
+	Srv     *drive.Service
+	cfg     *ConfigGoogleDrive
+	client  *http.Client
+	backend DriveBackend
+
+	// tokenStore хранилище OAuth токена
+	tokenStore TokenStore
+
+	// Ввод и вывод для авторизации без браузера, по умолчанию stdin и stderr
+	authInput  io.Reader
+	authOutput io.Writer
+
+	// Строки authInput, читаются одной горутиной на все авторизации диска
+	authInputLinesOnce sync.Once
+	authInputLines     <-chan authLine
+
+	// Ограничения скорости отправки: диска и общее для всех дисков, nil - без ограничения
+	rateLimit       *RateLimiter
+	globalRateLimit *RateLimiter
+
+	// retry политика повтора запросов при временных ошибках Drive, nil - без повторов
+	retry *RetryPolicy
+
+	// encryptor шифрование загружаемых файлов, nil - файлы загружаются как есть
+	encryptor *Encryptor
+
+	// nameTemplate шаблон имени файла в Drive, nil - имя локального файла или UseRemoteName
+	nameTemplate *NameTemplate
+}
+
+// NewGoogleDisk создаёт диск с произвольным хранилищем, например MemoryBackend для тестов.
+// Токен хранится в хранилище из cfg.TokenStore, другое задаёт SetTokenStore
+func NewGoogleDisk(cfg *ConfigGoogleDrive, backend DriveBackend) *GoogleDisk {
+	gd := &GoogleDisk{
+		cfg:     cfg,
+		backend: backend,
+	}
+	if store, err := NewTokenStore(cfg); err == nil {
+		gd.tokenStore = store
+	} else {
+		slog.Warn("хранилище токена не создано, токен не будет сохраняться", "idDisk", cfg.Id, "error", err)
+	}
+	return gd
+}
+
+// SetTokenStore задаёт хранилище OAuth токена диска
+func (gd *GoogleDisk) SetTokenStore(store TokenStore) {
+	gd.tokenStore = store
+}
+
+// SetRateLimit задаёт ограничение скорости отправки данных на диск, nil - без ограничения
+func (gd *GoogleDisk) SetRateLimit(limiter *RateLimiter) {
+	gd.rateLimit = limiter
+}
+
+// SetRetryPolicy задаёт политику повтора запросов к Drive при временных ошибках, nil - без повторов
+func (gd *GoogleDisk) SetRetryPolicy(policy *RetryPolicy) {
+	gd.retry = policy
+	if rb, ok := gd.backend.(*retryBackend); ok {
+		gd.backend = rb.next
+	}
+	if gd.backend != nil {
+		gd.backend = newRetryBackend(gd.backend, policy)
+	}
+}
+
+// SetEncryptor задаёт шифрование загружаемых и расшифровку скачиваемых файлов диска, nil - без шифрования
+func (gd *GoogleDisk) SetEncryptor(e *Encryptor) {
+	gd.encryptor = e
+}
+
+// SetNameTemplate задаёт шаблон имени загружаемых файлов в Drive, nil - без шаблона
+func (gd *GoogleDisk) SetNameTemplate(t *NameTemplate) {
+	gd.nameTemplate = t
+}
+
+// SetGlobalRateLimit задаёт общее ограничение скорости для всех одновременных загрузок на все диски
+func (gds *GoogleDisks) SetGlobalRateLimit(limiter *RateLimiter) {
+	for _, gd := range gds.ListGoogleDisk {
+		gd.globalRateLimit = limiter
+	}
+}
+
+// throttle ограничивает скорость чтения r ограничениями диска
+func (gd *GoogleDisk) throttle(ctx context.Context, r io.Reader) io.Reader {
+	return throttleReader(ctx, r, gd.rateLimit, gd.globalRateLimit)
+}
+
+// NewGoogleDisks объединяет диски, первый из них становится диском по умолчанию
+func NewGoogleDisks(disks ...*GoogleDisk) (*GoogleDisks, error) {
+	if len(disks) == 0 {
+		return nil, errors.New("no set config_google_drives")
+	}
+
+	gds := GoogleDisks{
+		GoogleDiskDefault: disks[0],
+		ListGoogleDisk:    disks,
+	}
+	return &gds, nil
 }
 
 // NewDriveService создаёт новый сервис Drive API
 func NewDriveService(ctx context.Context, config *Config) (*GoogleDisks, error) {
+	// Схема шифрования учётных данных и токенов
+	if err := config.SecretProtection.Apply(); err != nil {
+		return nil, err
+	}
+
 	var (
-		gdDefault      *GoogleDisk
 		listGoogleDisk = make([]*GoogleDisk, 0, len(config.ConfigGoogleDrives))
+		pending        []AuthRequest
 	)
 
 	// Получаем хост и порт для OAuth callback
@@ -34,50 +144,93 @@ func NewDriveService(ctx context.Context, config *Config) (*GoogleDisks, error)
 			continue
 		}
 
-		data, err := DecryptFile(cfg.GoogleCredentialsFile)
+		tokenStore, err := NewTokenStore(cfg)
 		if err != nil {
 			return nil, err
 		}
 
-		oauth2Config, err := google.ConfigFromJSON(data, drive.DriveScope)
+		rateLimit, err := NewScheduledRateLimiter(cfg.UploadRateLimit, cfg.UploadRateSchedule)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("disk %s: %w", cfg.Id, err)
+		}
+
+		encryptor, err := cfg.Encryption.Encryptor()
+		if err != nil {
+			return nil, fmt.Errorf("disk %s: %w", cfg.Id, err)
 		}
 
-		// Устанавливаем redirect URL для локального сервера авторизации
-		oauth2Config.RedirectURL = "http://" + callbackHostPort + "/oauth2/callback"
+		nameTemplate, err := ParseNameTemplate(cfg.RemoteNameTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("disk %s: %w", cfg.Id, err)
+		}
 
 		gd := &GoogleDisk{
-			cfg: cfg,
+			cfg:          cfg,
+			tokenStore:   tokenStore,
+			rateLimit:    rateLimit,
+			retry:        config.Retry.Policy(),
+			encryptor:    encryptor,
+			nameTemplate: nameTemplate,
 		}
 
-		token, err := gd.GetToken(oauth2Config)
+		client, oauth2Config, err := gd.newHTTPClient(ctx, callbackHostPort)
 		if err != nil {
 			return nil, err
 		}
 
-		client := oauth2Config.Client(ctx, token)
-		gd.Srv, err = drive.NewService(ctx, option.WithHTTPClient(client))
-		if err != nil {
+		if oauth2Config != nil {
+			// Авторизация дисков без токена выполняется ниже за один сеанс
+			pending = append(pending, AuthRequest{Disk: gd, Config: oauth2Config})
+		} else if err := gd.setClient(ctx, client); err != nil {
 			return nil, err
 		}
 
-		if gdDefault == nil {
-			gdDefault = gd
+		listGoogleDisk = append(listGoogleDisk, gd)
+	}
+
+	if len(pending) > 0 {
+		var errs []error
+		results := NewAuthManager().Authorize(ctx, pending)
+		for i, res := range results {
+			if res.Err != nil {
+				errs = append(errs, fmt.Errorf("disk %s: %w", res.DiskID, res.Err))
+				continue
+			}
+			gd := pending[i].Disk
+			if err := gd.setClient(ctx, gd.oauthClient(ctx, pending[i].Config, res.Token)); err != nil {
+				return nil, err
+			}
+		}
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("ошибка авторизации дисков: %w", errors.Join(errs...))
 		}
+	}
 
-		listGoogleDisk = append(listGoogleDisk, gd)
+	gds, err := NewGoogleDisks(listGoogleDisk...)
+	if err != nil {
+		return nil, err
 	}
+	gds.SelectPolicy = config.DiskSelectPolicy
 
-	if len(listGoogleDisk) == 0 {
-		return nil, errors.New("no set config_google_drives")
+	globalRateLimit, err := NewScheduledRateLimiter(config.UploadRateLimit, config.UploadRateSchedule)
+	if err != nil {
+		return nil, err
 	}
+	gds.SetGlobalRateLimit(globalRateLimit)
+	return gds, nil
+}
 
-	gds := GoogleDisks{
-		GoogleDiskDefault: gdDefault,
-		ListGoogleDisk:    listGoogleDisk,
+// setClient задаёт HTTP клиент с авторизацией и создаёт через него сервис Drive API
+func (gd *GoogleDisk) setClient(ctx context.Context, client *http.Client) error {
+	srv, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return err
 	}
-	return &gds, nil
+
+	gd.client = client
+	gd.Srv = srv
+	gd.backend = newRetryBackend(NewDriveBackend(srv), gd.retry)
+	return nil
 }
 
 func (gd *GoogleDisk) GetUrlFile() string {
@@ -0,0 +1,240 @@
+package googleupload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CodecGzip и CodecZstd - алгоритмы сжатия файлов перед загрузкой
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+
+	// PropCodec и PropOriginalSize - appProperties сжатого файла: алгоритм и размер до сжатия
+	PropCodec        = "gduCodec"
+	PropOriginalSize = "gduOriginalSize"
+)
+
+// codecExt - расширения, добавляемые к имени сжатого файла в Drive
+var codecExt = map[string]string{
+	CodecGzip: ".gz",
+	CodecZstd: ".zst",
+}
+
+// ConfigCompression сжатие файлов перед загрузкой, данные сжимаются потоком без временных файлов
+type ConfigCompression struct {
+	Codec string `yaml:"codec" mapstructure:"codec"` // gzip, zstd. Пусто - без сжатия
+	Level int    `yaml:"level" mapstructure:"level"` // gzip: 1-9, zstd: 1-4 (fastest, default, better, best). 0 - по умолчанию
+}
+
+// Validate проверяет алгоритм и уровень сжатия
+func (c ConfigCompression) Validate() error {
+	switch c.Codec {
+	case "":
+		return nil
+	case CodecGzip:
+		if c.Level < 0 || c.Level > gzip.BestCompression {
+			return fmt.Errorf("уровень сжатия gzip должен быть от 1 до 9: %d", c.Level)
+		}
+	case CodecZstd:
+		if c.Level < 0 || c.Level > int(zstd.SpeedBestCompression) {
+			return fmt.Errorf("уровень сжатия zstd должен быть от 1 до 4: %d", c.Level)
+		}
+	default:
+		return fmt.Errorf("неизвестный алгоритм сжатия: %s", c.Codec)
+	}
+	return nil
+}
+
+// Ext возвращает расширение сжатого файла, "" - без сжатия
+func (c ConfigCompression) Ext() string {
+	return codecExt[c.Codec]
+}
+
+// compressReadSize - размер чтения исходных данных при сжатии
+const compressReadSize = 32 * 1024
+
+// compressReader возвращает поток сжатых данных r. Данные читаются из r и сжимаются при чтении потока,
+// без отдельной горутины: пока поток не читается, r не трогается. Close освобождает кодировщик
+func compressReader(r io.Reader, c ConfigCompression) (io.ReadCloser, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	cr := &compressingReader{src: r}
+	switch c.Codec {
+	case CodecGzip:
+		level := c.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gw, err := gzip.NewWriterLevel(&cr.buf, level)
+		if err != nil {
+			return nil, err
+		}
+		cr.w = gw
+	case CodecZstd:
+		level := zstd.SpeedDefault
+		if c.Level > 0 {
+			level = zstd.EncoderLevel(c.Level)
+		}
+		zw, err := zstd.NewWriter(&cr.buf, zstd.WithEncoderLevel(level))
+		if err != nil {
+			return nil, err
+		}
+		cr.w = zw
+	}
+	return cr, nil
+}
+
+// compressingReader сжимает данные src по мере чтения: сжатые данные кодировщик пишет в buf
+type compressingReader struct {
+	src    io.Reader
+	w      io.WriteCloser
+	buf    bytes.Buffer
+	in     []byte
+	closed bool  // кодировщик закрыт
+	err    error // ошибка или io.EOF после того, как buf будет прочитан
+}
+
+func (c *compressingReader) Read(p []byte) (int, error) {
+	for c.buf.Len() == 0 && c.err == nil {
+		if c.in == nil {
+			c.in = make([]byte, compressReadSize)
+		}
+		n, err := c.src.Read(c.in)
+		if n > 0 {
+			if _, werr := c.w.Write(c.in[:n]); werr != nil {
+				c.err = werr
+				break
+			}
+		}
+		switch {
+		case errors.Is(err, io.EOF):
+			// Close дописывает конец сжатого потока
+			c.closed = true
+			if err := c.w.Close(); err != nil {
+				c.err = err
+			} else {
+				c.err = io.EOF
+			}
+		case err != nil:
+			// При ошибке чтения конец сжатого потока не нужен
+			c.err = err
+		}
+	}
+
+	if c.buf.Len() > 0 {
+		return c.buf.Read(p)
+	}
+	return 0, c.err
+}
+
+// Close освобождает кодировщик, если поток не дочитан. Непрочитанные сжатые данные отбрасываются
+func (c *compressingReader) Close() error {
+	if c.err == nil || c.err == io.EOF {
+		c.err = io.ErrClosedPipe
+	}
+	defer c.buf.Reset()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.w.Close()
+}
+
+// decompressReader возвращает поток распакованных данных r, сжатых алгоритмом codec
+func decompressReader(r io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("неизвестный алгоритм сжатия: %s", codec)
+	}
+}
+
+// storedName возвращает имя файла name в Drive с учётом сжатия и шифрования диска
+func (gd *GoogleDisk) storedName(name string) string {
+	name += gd.cfg.Compression.Ext()
+	if gd.encryptor == nil {
+		return name
+	}
+	return gd.encryptor.RemoteName(name)
+}
+
+// storedSize возвращает наибольший размер файла в Drive по размеру исходного для проверки свободного места.
+// Размер сжатых данных заранее неизвестен, поэтому при сжатии это оценка худшего случая - несжимаемых данных
+func (gd *GoogleDisk) storedSize(size int64) int64 {
+	if gd.cfg.Compression.Codec != "" {
+		size = compressBound(size)
+	}
+	if gd.encryptor == nil {
+		return size
+	}
+	return gd.encryptor.EncryptedSize(size)
+}
+
+// compressBound возвращает наибольший размер сжатых данных размером size: несжимаемые данные
+// становятся немного больше исходных из-за заголовков блоков (оценка как ZSTD_COMPRESSBOUND, покрывает и gzip)
+func compressBound(size int64) int64 {
+	return size + size>>8 + 64
+}
+
+// storedProperties добавляет к props свойства сжатого файла с исходным размером size, size < 0 - размер неизвестен
+func (gd *GoogleDisk) storedProperties(props map[string]string, size int64) map[string]string {
+	codec := gd.cfg.Compression.Codec
+	if codec == "" {
+		return props
+	}
+	merged := make(map[string]string, len(props)+2)
+	for k, v := range props {
+		merged[k] = v
+	}
+	merged[PropCodec] = codec
+	if size >= 0 {
+		merged[PropOriginalSize] = strconv.FormatInt(size, 10)
+	}
+	return merged
+}
+
+// encodeReader возвращает данные r для загрузки на диск: сжатые и зашифрованные, если это задано для диска.
+// Close останавливает сжатие, если поток не дочитан
+func (gd *GoogleDisk) encodeReader(r io.Reader) (io.ReadCloser, error) {
+	stop := func() error { return nil }
+	if gd.cfg.Compression.Codec != "" {
+		compressed, err := compressReader(r, gd.cfg.Compression)
+		if err != nil {
+			return nil, err
+		}
+		r, stop = compressed, compressed.Close
+	}
+
+	encrypted, err := gd.encryptReader(r)
+	if err != nil {
+		_ = stop()
+		return nil, err
+	}
+	return &encodedReader{Reader: encrypted, stop: stop}, nil
+}
+
+// encodedReader данные для загрузки, Close останавливает сжатие
+type encodedReader struct {
+	io.Reader
+	stop func() error
+}
+
+func (r *encodedReader) Close() error {
+	return r.stop()
+}
@@ -0,0 +1,117 @@
+package googleupload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+)
+
+// readCounter считает вызовы Read источника
+type readCounter struct {
+	reader io.Reader
+	reads  int
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	r.reads++
+	return r.reader.Read(p)
+}
+
+func TestCompressReaderRoundTrip(t *testing.T) {
+	random := make([]byte, 300<<10)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	inputs := map[string][]byte{
+		"пустые":      nil,
+		"сжимаемые":   []byte(strings.Repeat("INSERT INTO t VALUES (1);\n", 20000)),
+		"несжимаемые": random,
+		"одна строка": []byte("backup"),
+	}
+
+	for _, codec := range []string{CodecGzip, CodecZstd} {
+		for name, data := range inputs {
+			compressed, err := compressReader(bytes.NewReader(data), ConfigCompression{Codec: codec})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := io.ReadAll(compressed)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", codec, name, err)
+			}
+			if err := compressed.Close(); err != nil {
+				t.Errorf("%s, %s: закрытие дочитанного потока: %v", codec, name, err)
+			}
+			if int64(len(stored)) > compressBound(int64(len(data))) {
+				t.Errorf("%s, %s: сжато в %d байт, больше оценки %d", codec, name, len(stored), compressBound(int64(len(data))))
+			}
+
+			zr, err := decompressReader(bytes.NewReader(stored), codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(zr)
+			_ = zr.Close()
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%s, %s: распаковано %d байт из %d: %v", codec, name, len(got), len(data), err)
+			}
+		}
+	}
+}
+
+func TestCompressReaderLazy(t *testing.T) {
+	for _, codec := range []string{CodecGzip, CodecZstd} {
+		source := &readCounter{reader: strings.NewReader("backup")}
+		gd := NewGoogleDisk(&ConfigGoogleDrive{Id: "d", Compression: ConfigCompression{Codec: codec}}, NewMemoryBackend(0))
+		encoded, err := gd.encodeReader(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Загрузка прервана до чтения: источник не тронут
+		if err := encoded.Close(); err != nil {
+			t.Errorf("%s: %v", codec, err)
+		}
+		if source.reads != 0 {
+			t.Errorf("%s: источник прочитан %d раз до чтения сжатого потока", codec, source.reads)
+		}
+		if n, err := encoded.Read(make([]byte, 10)); n != 0 || err == nil {
+			t.Errorf("%s: чтение после Close: %d, %v", codec, n, err)
+		}
+	}
+}
+
+func TestCompressReaderSourceError(t *testing.T) {
+	failing := io.MultiReader(strings.NewReader("partial"), iotestErrReader{})
+	compressed, err := compressReader(failing, ConfigCompression{Codec: CodecGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(compressed); err != io.ErrUnexpectedEOF {
+		t.Errorf("ожидалась ошибка источника, получено %v", err)
+	}
+	if err := compressed.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// iotestErrReader источник, чтение которого обрывается
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestStoredSize(t *testing.T) {
+	plain := NewGoogleDisk(&ConfigGoogleDrive{Id: "d"}, NewMemoryBackend(0))
+	compressed := NewGoogleDisk(&ConfigGoogleDrive{Id: "d", Compression: ConfigCompression{Codec: CodecZstd}}, NewMemoryBackend(0))
+	if got := plain.storedSize(1 << 20); got != 1<<20 {
+		t.Errorf("без сжатия: %d", got)
+	}
+	// Для проверки места сжатие оценивается худшим случаем, не меньше исходного размера
+	if got := compressed.storedSize(1 << 20); got < 1<<20 || got != compressBound(1<<20) {
+		t.Errorf("со сжатием: %d", got)
+	}
+}
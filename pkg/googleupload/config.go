@@ -11,8 +11,13 @@ import (
 const ConfigFilyDefault = "config.yaml"
 
 type Config struct {
-	OAuthCallbackHostPort string             `yaml:"oauth_callback_host_port" mapstructure:"oauth_callback_host_port" default:"localhost:8080"` // Хост и порт для OAuth callback (по умолчанию "localhost:8080")
-	ConfigGoogleDrives    ConfigGoogleDrives `yaml:"config_google_drives" mapstructure:"config_google_drives"`
+	OAuthCallbackHostPort string                 `yaml:"oauth_callback_host_port" mapstructure:"oauth_callback_host_port" default:"localhost:8080"` // Хост и порт для OAuth callback (по умолчанию "localhost:8080")
+	ConfigGoogleDrives    ConfigGoogleDrives     `yaml:"config_google_drives" mapstructure:"config_google_drives"`
+	DiskSelectPolicy      SelectPolicy           `yaml:"disk_select_policy" mapstructure:"disk_select_policy"`     // Политика выбора диска, если idDisk не указан: first-fit, most-free, round-robin
+	SecretProtection      ConfigSecretProtection `yaml:"secret_protection" mapstructure:"secret_protection"`       // Шифрование учётных данных и токенов на диске
+	UploadRateLimit       string                 `yaml:"upload_rate_limit" mapstructure:"upload_rate_limit"`       // Общее ограничение скорости отправки на все диски, например 4MB (в секунду)
+	UploadRateSchedule    []RateWindow           `yaml:"upload_rate_schedule" mapstructure:"upload_rate_schedule"` // Общее ограничение скорости по времени суток
+	Retry                 ConfigRetry            `yaml:"retry" mapstructure:"retry"`                               // Повтор запросов к Drive при временных ошибках
 }
 
 type ConfigGoogleDrives []*ConfigGoogleDrive
@@ -23,6 +28,21 @@ type ConfigGoogleDrive struct {
 	UploadCopiesCount     int    `yaml:"upload_copies_count" mapstructure:"upload_copies_count" default:"1"`
 	FolderID              string `yaml:"folder_id" mapstructure:"folder_id"`
 	Enable                bool   `yaml:"enable" mapstructure:"enable" default:"true"`
+	ResumableUpload       bool   `yaml:"resumable_upload" mapstructure:"resumable_upload"`                // Загрузка по частям с продолжением после перезапуска
+	ChunkSizeMB           int    `yaml:"chunk_size_mb" mapstructure:"chunk_size_mb" default:"8"`          // Размер части при загрузке по частям, МБ
+	AuthFlow              string `yaml:"auth_flow" mapstructure:"auth_flow" default:"loopback"`           // Способ авторизации OAuth: loopback, device, manual
+	AuthType              string `yaml:"auth_type" mapstructure:"auth_type"`                              // Способ аутентификации: oauth, service_account, adc. По умолчанию по полю type файла учётных данных
+	ImpersonateSubject    string `yaml:"impersonate_subject" mapstructure:"impersonate_subject"`          // Пользователь домена для сервисного аккаунта с делегированием
+	TokenStore            string `yaml:"token_store" mapstructure:"token_store" default:"encrypted_file"` // Хранилище OAuth токена: encrypted_file, file, keyring или зарегистрированное RegisterTokenStore
+	TokenFile             string `yaml:"token_file" mapstructure:"token_file"`                            // Файл токена, по умолчанию <google_credentials_file без расширения>_token.json
+	VerifySHA256          bool   `yaml:"verify_sha256" mapstructure:"verify_sha256"`                      // Кроме MD5 проверять SHA-256 загруженного файла
+	RemoteNameTemplate    string `yaml:"remote_name_template" mapstructure:"remote_name_template"`        // Шаблон имени файла в Drive, например {host}/{base}-{date:2006-01-02_1504}{ext}
+
+	UploadRateLimit    string       `yaml:"upload_rate_limit" mapstructure:"upload_rate_limit"`       // Ограничение скорости отправки на диск, например 2MB (в секунду)
+	UploadRateSchedule []RateWindow `yaml:"upload_rate_schedule" mapstructure:"upload_rate_schedule"` // Ограничение скорости по времени суток, вне интервалов действует upload_rate_limit
+
+	Encryption  ConfigEncryption  `yaml:"encryption" mapstructure:"encryption"`   // Шифрование файлов перед загрузкой
+	Compression ConfigCompression `yaml:"compression" mapstructure:"compression"` // Сжатие файлов перед загрузкой, выполняется до шифрования
 }
 
 // LoadConfig загружает конфигурацию из YAML файлов
@@ -49,6 +69,20 @@ func LoadConfig(yamlFiles ...string) (*Config, error) {
 		return nil, fmt.Errorf("ошибка установки значений по умолчанию: %v", err)
 	}
 
+	if cfg.DiskSelectPolicy != "" {
+		if _, ok := ParseSelectPolicy(string(cfg.DiskSelectPolicy)); !ok {
+			return nil, fmt.Errorf("неизвестная политика выбора диска: %s", cfg.DiskSelectPolicy)
+		}
+	}
+
+	if _, err := NewScheduledRateLimiter(cfg.UploadRateLimit, cfg.UploadRateSchedule); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Retry.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Валидируем конфигурацию Google Drive
 	for _, drive := range cfg.ConfigGoogleDrives {
 		if drive.Enable {
@@ -73,7 +107,47 @@ func loadYaml(file string, cfg *Config) error {
 
 // Validate проверяет конфигурацию Google Drive
 func (c *ConfigGoogleDrive) Validate() error {
-	_, err := os.Stat(c.GoogleCredentialsFile)
+	if _, err := NewScheduledRateLimiter(c.UploadRateLimit, c.UploadRateSchedule); err != nil {
+		return fmt.Errorf("disk %s: %w", c.Id, err)
+	}
+
+	if _, err := c.Encryption.Encryptor(); err != nil {
+		return fmt.Errorf("disk %s: %w", c.Id, err)
+	}
+
+	if err := c.Compression.Validate(); err != nil {
+		return fmt.Errorf("disk %s: %w", c.Id, err)
+	}
+
+	if err := checkCopiesCount(c.UploadCopiesCount); err != nil {
+		return fmt.Errorf("disk %s: upload_copies_count: %w", c.Id, err)
+	}
+
+	nameTemplate, err := ParseNameTemplate(c.RemoteNameTemplate)
+	if err != nil {
+		return fmt.Errorf("disk %s: %w", c.Id, err)
+	}
+	if nameTemplate != nil && nameTemplate.HasDate() && c.Encryption.EncryptNames {
+		// По хешу имени нельзя узнать, что копия относится к шаблону
+		return fmt.Errorf("disk %s: remote_name_template с {date} несовместим с encrypt_names, копии не найти для ротации", c.Id)
+	}
+
+	switch c.AuthFlow {
+	case AuthFlowLoopback, AuthFlowDevice, AuthFlowManual:
+	default:
+		return fmt.Errorf("неизвестный способ авторизации auth_flow: %s", c.AuthFlow)
+	}
+
+	switch c.AuthType {
+	case "", AuthTypeOAuth, AuthTypeServiceAccount:
+	case AuthTypeADC:
+		// Файл учётных данных для ADC необязателен
+		return nil
+	default:
+		return fmt.Errorf("неизвестный способ аутентификации auth_type: %s", c.AuthType)
+	}
+
+	_, err = os.Stat(c.GoogleCredentialsFile)
 	if err == nil {
 		return nil
 	}
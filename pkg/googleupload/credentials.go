@@ -0,0 +1,109 @@
+package googleupload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+// Способы аутентификации для ConfigGoogleDrive.AuthType
+const (
+	// AuthTypeOAuth - OAuth клиент установленного приложения с интерактивным получением токена
+	AuthTypeOAuth = "oauth"
+	// AuthTypeServiceAccount - JSON ключ сервисного аккаунта, опционально с делегированием на домен (ImpersonateSubject)
+	AuthTypeServiceAccount = "service_account"
+	// AuthTypeADC - Application Default Credentials: GOOGLE_APPLICATION_CREDENTIALS, gcloud,
+	// метаданные GCE/GKE (workload identity) или файл external_account из GoogleCredentialsFile
+	AuthTypeADC = "adc"
+)
+
+// newHTTPClient создаёт HTTP клиент с авторизацией согласно AuthType диска.
+// Если диску нужна интерактивная авторизация OAuth, возвращает вместо клиента конфигурацию OAuth для AuthManager
+func (gd *GoogleDisk) newHTTPClient(ctx context.Context, callbackHostPort string) (*http.Client, *oauth2.Config, error) {
+	l := slog.With("idDisk", gd.cfg.Id)
+
+	// Для ADC без файла учётные данные ищутся в окружении
+	if gd.cfg.AuthType == AuthTypeADC && !fileExists(gd.cfg.GoogleCredentialsFile) {
+		creds, err := google.FindDefaultCredentials(ctx, drive.DriveScope)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ошибка поиска Application Default Credentials: %w", err)
+		}
+		l.Info("Используются Application Default Credentials")
+		return oauth2.NewClient(ctx, creds.TokenSource), nil, nil
+	}
+
+	data, err := DecryptFile(gd.cfg.GoogleCredentialsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authType := gd.cfg.AuthType
+	if authType == "" {
+		authType = detectAuthType(data)
+	}
+
+	switch authType {
+	case AuthTypeServiceAccount, AuthTypeADC:
+		creds, err := google.CredentialsFromJSONWithParams(ctx, data, google.CredentialsParams{
+			Scopes:  []string{drive.DriveScope},
+			Subject: gd.cfg.ImpersonateSubject,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("ошибка чтения учётных данных %s: %w", gd.cfg.GoogleCredentialsFile, err)
+		}
+		l.Info("Используются учётные данные без интерактивной авторизации", "authType", authType, "subject", gd.cfg.ImpersonateSubject)
+		return oauth2.NewClient(ctx, creds.TokenSource), nil, nil
+	}
+
+	oauth2Config, err := google.ConfigFromJSON(data, authScopes(gd.cfg.AuthFlow)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Устанавливаем redirect URL для локального сервера авторизации
+	oauth2Config.RedirectURL = "http://" + callbackHostPort + "/oauth2/callback"
+
+	token, ok := gd.loadToken(oauth2Config)
+	if !ok {
+		return nil, oauth2Config, nil
+	}
+	return gd.oauthClient(ctx, oauth2Config, token), nil, nil
+}
+
+// oauthClient создаёт HTTP клиент OAuth, каждое обновление токена во время работы сохраняется в хранилище
+func (gd *GoogleDisk) oauthClient(ctx context.Context, config *oauth2.Config, token *oauth2.Token) *http.Client {
+	l := slog.With("idDisk", gd.cfg.Id)
+	ts := newPersistingTokenSource(config.TokenSource(ctx, token), gd.tokenStore, token, l)
+	return oauth2.NewClient(ctx, ts)
+}
+
+// detectAuthType определяет способ аутентификации по полю type файла учётных данных
+func detectAuthType(data []byte) string {
+	var file struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return AuthTypeOAuth
+	}
+
+	switch file.Type {
+	case "service_account":
+		return AuthTypeServiceAccount
+	case "external_account", "authorized_user", "impersonated_service_account", "external_account_authorized_user":
+		return AuthTypeADC
+	}
+	// У OAuth клиента поля type нет, есть секция installed или web
+	return AuthTypeOAuth
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
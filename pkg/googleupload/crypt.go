@@ -2,11 +2,12 @@ package googleupload
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
-
-	"github.com/billgraziano/dpapi"
+	"sync"
 )
 
 var (
@@ -15,26 +16,130 @@ var (
 )
 
 const (
-	// EncryptedMarker - маркер для определения зашифрованного файла
+	// EncryptedMarker - маркер файла, зашифрованного DPAPI до появления версионного заголовка
 	EncryptedMarker = "DPAPI_ENCRYPTED:"
+
+	// SecretHeaderPrefix - начало версионного заголовка защищённого файла: GDU-SECRET:v1:<схема>:<base64>
+	SecretHeaderPrefix = "GDU-SECRET:"
+	// secretHeaderVersion - текущая версия формата заголовка
+	secretHeaderVersion = "v1"
+)
+
+// SecretProtector схема защиты секретов (учётных данных и токенов) на диске
+type SecretProtector interface {
+	// Scheme возвращает имя схемы, записываемое в заголовок файла
+	Scheme() string
+	// Protect шифрует данные
+	Protect(data []byte) ([]byte, error)
+	// Unprotect расшифровывает данные
+	Unprotect(data []byte) ([]byte, error)
+}
+
+var (
+	protectorsMu sync.RWMutex
+	// protectors - зарегистрированные схемы, по ним расшифровываются файлы
+	protectors = map[string]SecretProtector{
+		SchemeDPAPI: dpapiProtector{},
+	}
+	// activeProtector - схема для шифрования новых и перешифрования старых файлов, nil - без шифрования
+	activeProtector SecretProtector = defaultProtector()
 )
 
+// RegisterSecretProtector регистрирует схему, чтобы файлы, зашифрованные ею, можно было расшифровать
+func RegisterSecretProtector(p SecretProtector) {
+	protectorsMu.Lock()
+	defer protectorsMu.Unlock()
+
+	protectors[p.Scheme()] = p
+}
+
+// SetSecretProtector регистрирует схему и делает её схемой для шифрования.
+// nil отключает шифрование новых файлов, уже зашифрованные файлы по-прежнему расшифровываются
+func SetSecretProtector(p SecretProtector) {
+	protectorsMu.Lock()
+	defer protectorsMu.Unlock()
+
+	if p != nil {
+		protectors[p.Scheme()] = p
+	}
+	activeProtector = p
+}
+
+func getActiveProtector() SecretProtector {
+	protectorsMu.RLock()
+	defer protectorsMu.RUnlock()
+
+	return activeProtector
+}
+
+func getProtector(scheme string) (SecretProtector, error) {
+	protectorsMu.RLock()
+	defer protectorsMu.RUnlock()
+
+	p, ok := protectors[scheme]
+	if !ok {
+		return nil, fmt.Errorf("неизвестная схема шифрования: %s", scheme)
+	}
+	return p, nil
+}
+
 // EncryptBytesWithDPAPI шифрует данные с использованием Windows DPAPI
 func EncryptBytesWithDPAPI(data []byte) ([]byte, error) {
-	return dpapi.EncryptBytesEntropy(data, EntropyBytes)
+	return dpapiProtector{}.Protect(data)
 }
 
 // DecryptBytesWithDPAPI дешифрует данные с использованием Windows DPAPI
 func DecryptBytesWithDPAPI(data []byte) ([]byte, error) {
-	return dpapi.DecryptBytesEntropy(data, EntropyBytes)
+	return dpapiProtector{}.Unprotect(data)
 }
 
 // IsFileEncrypted проверяет, зашифрован ли файл по содержимому
 func IsFileEncrypted(content []byte) bool {
-	return strings.HasPrefix(string(content), EncryptedMarker)
+	s := string(content)
+	return strings.HasPrefix(s, SecretHeaderPrefix) || strings.HasPrefix(s, EncryptedMarker)
+}
+
+// parseSecret возвращает схему и зашифрованные данные из содержимого защищённого файла
+func parseSecret(content []byte) (string, []byte, error) {
+	s := string(content)
+
+	var scheme, encoded string
+	if rest, ok := strings.CutPrefix(s, EncryptedMarker); ok {
+		// Старый формат без версии всегда означает DPAPI
+		scheme, encoded = SchemeDPAPI, rest
+	} else {
+		rest := strings.TrimPrefix(s, SecretHeaderPrefix)
+		parts := strings.SplitN(rest, ":", 3)
+		if len(parts) != 3 {
+			return "", nil, errors.New("неверный заголовок зашифрованного файла")
+		}
+		if parts[0] != secretHeaderVersion {
+			return "", nil, fmt.Errorf("неподдерживаемая версия заголовка зашифрованного файла: %s", parts[0])
+		}
+		scheme, encoded = parts[1], parts[2]
+	}
+
+	// Декодируем base64
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка декодирования base64: %v", err)
+	}
+	return scheme, data, nil
+}
+
+// protectContent шифрует данные схемой p и добавляет версионный заголовок
+func protectContent(p SecretProtector, content []byte) ([]byte, error) {
+	encryptedData, err := p.Protect(content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Используем base64 для безопасного хранения бинарных данных в файле
+	header := SecretHeaderPrefix + secretHeaderVersion + ":" + p.Scheme() + ":"
+	return []byte(header + base64.StdEncoding.EncodeToString(encryptedData)), nil
 }
 
-// EncryptFile шифрует файл с использованием DPAPI
+// EncryptFile шифрует файл текущей схемой защиты
 func EncryptFile(filePath string) error {
 	content, err := os.ReadFile(filePath)
 	if err != nil {
@@ -54,69 +159,117 @@ func EncryptFile(filePath string) error {
 	return err
 }
 
+// EncryptContentAndSaveToFile шифрует данные текущей схемой защиты и записывает в файл.
+// Если схема не задана, данные записываются без шифрования
 func EncryptContentAndSaveToFile(filePath string, content []byte) error {
-	// Шифруем данные
-	encryptedData, err := EncryptBytesWithDPAPI(content)
-	if err != nil {
-		return err
+	data := content
+	if p := getActiveProtector(); p != nil {
+		var err error
+		data, err = protectContent(p, content)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Добавляем маркер в начало зашифрованных данных
-	// Используем base64 для безопасного хранения бинарных данных в файле
-	encodedData := EncryptedMarker + base64.StdEncoding.EncodeToString(encryptedData)
-
-	// Записываем зашифрованные данные обратно в файл
-	err = os.WriteFile(filePath, []byte(encodedData), 0600)
-	return err
+	return os.WriteFile(filePath, data, 0600)
 }
 
-// DecryptFile дешифрует файл с использованием DPAPI
-// Если файл не зашифрован, он будет зашифрован и сохранён
+// DecryptFile дешифрует файл схемой, указанной в его заголовке. Незашифрованный файл возвращается как есть.
+// Файл не изменяется, перешифровать его текущей схемой можно MigrateSecretFile
 func DecryptFile(filePath string) ([]byte, error) {
 	content, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка чтения файла %s: %v", filePath, err)
 	}
+	if !IsFileEncrypted(content) {
+		return content, nil
+	}
 
+	_, decryptedData, err := decryptContent(content)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, filePath)
+	}
+	return decryptedData, nil
+}
+
+// MigrateSecretFile шифрует текущей схемой защиты незашифрованный файл или файл, зашифрованный другой схемой,
+// в том числе в старом формате DPAPI_ENCRYPTED. Возвращает true, если файл перезаписан
+func MigrateSecretFile(filePath string) (bool, error) {
+	active := getActiveProtector()
+	if active == nil {
+		return false, nil
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return false, fmt.Errorf("ошибка чтения файла %s: %v", filePath, err)
+	}
 	if len(content) == 0 {
-		return content, nil
+		return false, nil
 	}
 
-	// Проверяем, зашифрован ли файл
-	if !IsFileEncrypted(content) {
-		// Файл не зашифрован - шифруем и сохраняем
-		encryptedData, err := EncryptBytesWithDPAPI(content)
-		if err != nil {
-			return nil, fmt.Errorf("ошибка шифрования файла: %v", err)
+	from, data := "", content
+	if IsFileEncrypted(content) {
+		if from, data, err = decryptContent(content); err != nil {
+			return false, fmt.Errorf("%v: %s", err, filePath)
 		}
-
-		encodedData := EncryptedMarker + base64.StdEncoding.EncodeToString(encryptedData)
-		if err := os.WriteFile(filePath, []byte(encodedData), 0600); err != nil {
-			return nil, fmt.Errorf("ошибка записи зашифрованного файла: %v", err)
+		if from == active.Scheme() && !strings.HasPrefix(string(content), EncryptedMarker) {
+			return false, nil
 		}
+	}
 
-		// Возвращаем оригинальные (незашифрованные) данные
-		return content, nil
+	if err := EncryptContentAndSaveToFile(filePath, data); err != nil {
+		return false, fmt.Errorf("ошибка шифрования файла %s: %v", filePath, err)
 	}
+	slog.Info("файл перешифрован", "file", filePath, "from", from, "to", active.Scheme())
+	return true, nil
+}
 
-	// Извлекаем base64-данные (убираем маркер)
-	lenEM := len(EncryptedMarker)
-	if len(content) < lenEM {
-		return nil, fmt.Errorf("маркер зашифрованного файла не найден: %s", filePath)
+// MigrateSecrets применяет SecretProtection и перешифровывает его текущей схемой файлы учётных данных
+// и токенов в хранилище encrypted_file всех дисков. Отсутствующие файлы пропускаются. Возвращает перезаписанные файлы
+func (c *Config) MigrateSecrets() ([]string, error) {
+	if err := c.SecretProtection.Apply(); err != nil {
+		return nil, err
 	}
-	encodedContent := string(content[lenEM:])
 
-	// Декодируем base64
-	encryptedData, err := base64.StdEncoding.DecodeString(encodedContent)
+	var migrated []string
+	for _, cfg := range c.ConfigGoogleDrives {
+		files := []string{cfg.GoogleCredentialsFile}
+		if cfg.TokenStore == "" || cfg.TokenStore == TokenStoreEncryptedFile {
+			files = append(files, cfg.tokenFile())
+		}
+		for _, file := range files {
+			if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			ok, err := MigrateSecretFile(file)
+			if err != nil {
+				return migrated, fmt.Errorf("диск %s: %w", cfg.Id, err)
+			}
+			if ok {
+				migrated = append(migrated, file)
+			}
+		}
+	}
+	return migrated, nil
+}
+
+// decryptContent возвращает схему и расшифрованные данные содержимого защищённого файла
+func decryptContent(content []byte) (string, []byte, error) {
+	scheme, encryptedData, err := parseSecret(content)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка декодирования base64: %v", err)
+		return "", nil, err
 	}
 
-	// Дешифруем данные
-	decryptedData, err := DecryptBytesWithDPAPI(encryptedData)
+	p, err := getProtector(scheme)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка дешифрования файла: %s error: %v", filePath, err)
+		return "", nil, err
 	}
 
-	return decryptedData, nil
+	// Дешифруем данные
+	decryptedData, err := p.Unprotect(encryptedData)
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка дешифрования файла: %v", err)
+	}
+	return scheme, decryptedData, nil
 }
@@ -0,0 +1,216 @@
+package googleupload
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// SchemeAESGCM - AES-256-GCM с ключом 32 байта из файла или переменной окружения
+	SchemeAESGCM = "aes-gcm"
+	// SchemeAESGCMScrypt - AES-256-GCM с ключом из пароля через scrypt, соль хранится в каждом файле
+	SchemeAESGCMScrypt = "aes-gcm-scrypt"
+	// SchemeNone - секреты хранятся без шифрования, например в контейнере с подключёнными секретами
+	SchemeNone = "none"
+
+	aesKeySize = 32
+	saltSize   = 16
+)
+
+// aesGCMProtector защита секретов AES-256-GCM, работает на любой ОС
+type aesGCMProtector struct {
+	scheme     string
+	key        []byte
+	passphrase []byte
+}
+
+// NewAESGCMProtector создаёт схему AES-256-GCM с ключом длиной 32 байта
+func NewAESGCMProtector(key []byte) (SecretProtector, error) {
+	if len(key) != aesKeySize {
+		return nil, fmt.Errorf("ключ AES-256 должен быть %d байт, получено %d", aesKeySize, len(key))
+	}
+	return &aesGCMProtector{scheme: SchemeAESGCM, key: key}, nil
+}
+
+// NewPassphraseProtector создаёт схему AES-256-GCM с ключом из пароля (scrypt)
+func NewPassphraseProtector(passphrase string) (SecretProtector, error) {
+	if passphrase == "" {
+		return nil, errors.New("пустой пароль для шифрования секретов")
+	}
+	return &aesGCMProtector{scheme: SchemeAESGCMScrypt, passphrase: []byte(passphrase)}, nil
+}
+
+func (p *aesGCMProtector) Scheme() string {
+	return p.scheme
+}
+
+// Protect возвращает [соль] + nonce + шифротекст, соль есть только у схемы с паролем
+func (p *aesGCMProtector) Protect(data []byte) ([]byte, error) {
+	var salt []byte
+	if p.passphrase != nil {
+		salt = make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+
+	aead, err := p.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(salt, nonce...)
+	return aead.Seal(out, nonce, data, []byte(p.scheme)), nil
+}
+
+func (p *aesGCMProtector) Unprotect(data []byte) ([]byte, error) {
+	var salt []byte
+	if p.passphrase != nil {
+		if len(data) < saltSize {
+			return nil, errors.New("зашифрованные данные повреждены")
+		}
+		salt, data = data[:saltSize], data[saltSize:]
+	}
+
+	aead, err := p.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("зашифрованные данные повреждены")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(p.scheme))
+}
+
+// aead возвращает AES-256-GCM с ключом схемы или ключом из пароля и соли
+func (p *aesGCMProtector) aead(salt []byte) (cipher.AEAD, error) {
+	key := p.key
+	if p.passphrase != nil {
+		var err error
+		key, err = scrypt.Key(p.passphrase, salt, 1<<15, 8, 1, aesKeySize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ConfigSecretProtection настройки защиты учётных данных и токенов на диске
+type ConfigSecretProtection struct {
+	Scheme        string `yaml:"scheme" mapstructure:"scheme"`                 // dpapi, aes-gcm, aes-gcm-scrypt, none. По умолчанию dpapi в Windows, иначе по заданному ключу, без ключа - ошибка
+	KeyFile       string `yaml:"key_file" mapstructure:"key_file"`             // Файл с ключом AES-256: 32 байта или base64
+	KeyEnv        string `yaml:"key_env" mapstructure:"key_env"`               // Переменная окружения с ключом AES-256 в base64
+	PassphraseEnv string `yaml:"passphrase_env" mapstructure:"passphrase_env"` // Переменная окружения с паролем
+}
+
+// Apply регистрирует все схемы, для которых заданы ключи, чтобы расшифровать файлы любой из них,
+// и выбирает схему для шифрования новых файлов. Существующие файлы перешифровывает Config.MigrateSecrets.
+// Если схема не задана и в ОС нет схемы по умолчанию, возвращает ошибку: без шифрования
+// секреты хранятся только при явном scheme: none
+func (c *ConfigSecretProtection) Apply() error {
+	var keyProtector, passProtector SecretProtector
+
+	key, err := c.loadKey()
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		if keyProtector, err = NewAESGCMProtector(key); err != nil {
+			return err
+		}
+		RegisterSecretProtector(keyProtector)
+	}
+
+	if c.PassphraseEnv != "" {
+		if passphrase := os.Getenv(c.PassphraseEnv); passphrase != "" {
+			if passProtector, err = NewPassphraseProtector(passphrase); err != nil {
+				return err
+			}
+			RegisterSecretProtector(passProtector)
+		}
+	}
+
+	scheme := c.Scheme
+	if scheme == "" {
+		switch {
+		case defaultProtector() != nil:
+			scheme = defaultProtector().Scheme()
+		case keyProtector != nil:
+			scheme = SchemeAESGCM
+		case passProtector != nil:
+			scheme = SchemeAESGCMScrypt
+		default:
+			return errors.New("схема шифрования секретов не задана: задайте в secret_protection key_file, key_env " +
+				"или passphrase_env, либо scheme: none, чтобы хранить учётные данные и токены без шифрования")
+		}
+	}
+
+	switch scheme {
+	case SchemeNone:
+		SetSecretProtector(nil)
+	case SchemeDPAPI:
+		SetSecretProtector(dpapiProtector{})
+	case SchemeAESGCM:
+		if keyProtector == nil {
+			return errors.New("для схемы aes-gcm задайте key_file или key_env")
+		}
+		SetSecretProtector(keyProtector)
+	case SchemeAESGCMScrypt:
+		if passProtector == nil {
+			return fmt.Errorf("для схемы aes-gcm-scrypt задайте пароль в переменной окружения passphrase_env (%s)", c.PassphraseEnv)
+		}
+		SetSecretProtector(passProtector)
+	default:
+		return fmt.Errorf("неизвестная схема шифрования секретов: %s", scheme)
+	}
+	return nil
+}
+
+// loadKey читает ключ AES-256 из файла или переменной окружения, nil - ключ не задан
+func (c *ConfigSecretProtection) loadKey() ([]byte, error) {
+	var raw string
+	switch {
+	case c.KeyFile != "":
+		data, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения файла ключа %s: %v", c.KeyFile, err)
+		}
+		if len(data) == aesKeySize {
+			return data, nil
+		}
+		raw = string(data)
+	case c.KeyEnv != "":
+		raw = os.Getenv(c.KeyEnv)
+		if raw == "" {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("ключ AES-256 должен быть 32 байта или base64: %v", err)
+	}
+	return key, nil
+}
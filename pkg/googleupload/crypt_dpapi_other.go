@@ -0,0 +1,33 @@
+//go:build !windows
+
+package googleupload
+
+import (
+	"errors"
+)
+
+// SchemeDPAPI - шифрование Windows DPAPI, ключ привязан к пользователю Windows
+const SchemeDPAPI = "dpapi"
+
+// errDPAPIUnsupported - DPAPI доступен только в Windows
+var errDPAPIUnsupported = errors.New("DPAPI доступен только в Windows, задайте secret_protection")
+
+// dpapiProtector вне Windows только сообщает, что файл зашифрован DPAPI на другой машине
+type dpapiProtector struct{}
+
+func (dpapiProtector) Scheme() string {
+	return SchemeDPAPI
+}
+
+func (dpapiProtector) Protect([]byte) ([]byte, error) {
+	return nil, errDPAPIUnsupported
+}
+
+func (dpapiProtector) Unprotect([]byte) ([]byte, error) {
+	return nil, errDPAPIUnsupported
+}
+
+// defaultProtector - вне Windows схема по умолчанию не задана, её нужно выбрать в secret_protection
+func defaultProtector() SecretProtector {
+	return nil
+}
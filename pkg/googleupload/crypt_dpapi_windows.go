@@ -0,0 +1,30 @@
+//go:build windows
+
+package googleupload
+
+import (
+	"github.com/billgraziano/dpapi"
+)
+
+// SchemeDPAPI - шифрование Windows DPAPI, ключ привязан к пользователю Windows
+const SchemeDPAPI = "dpapi"
+
+// dpapiProtector защита секретов через Windows DPAPI
+type dpapiProtector struct{}
+
+func (dpapiProtector) Scheme() string {
+	return SchemeDPAPI
+}
+
+func (dpapiProtector) Protect(data []byte) ([]byte, error) {
+	return dpapi.EncryptBytesEntropy(data, EntropyBytes)
+}
+
+func (dpapiProtector) Unprotect(data []byte) ([]byte, error) {
+	return dpapi.DecryptBytesEntropy(data, EntropyBytes)
+}
+
+// defaultProtector - схема по умолчанию: в Windows это DPAPI
+func defaultProtector() SecretProtector {
+	return dpapiProtector{}
+}
@@ -0,0 +1,158 @@
+package googleupload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useSecretProtector делает p схемой шифрования на время теста
+func useSecretProtector(t *testing.T, p SecretProtector) {
+	t.Helper()
+	previous := getActiveProtector()
+	t.Cleanup(func() { SetSecretProtector(previous) })
+	SetSecretProtector(p)
+}
+
+func newTestAESProtector(t *testing.T, fill byte) SecretProtector {
+	t.Helper()
+	p, err := NewAESGCMProtector(bytes.Repeat([]byte{fill}, aesKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func newTestPassphraseProtector(t *testing.T) SecretProtector {
+	t.Helper()
+	p, err := NewPassphraseProtector("пароль")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDecryptFileDoesNotRewrite(t *testing.T) {
+	dir := t.TempDir()
+	old := newTestAESProtector(t, 1)
+	useSecretProtector(t, old)
+	encrypted := filepath.Join(dir, "token.json")
+	if err := EncryptContentAndSaveToFile(encrypted, []byte(`{"access_token":"old"}`)); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "credentials.json")
+	if err := os.WriteFile(plain, []byte(`{"installed":{}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Схема сменилась: файлы прежней схемы и незашифрованные читаются без перезаписи
+	useSecretProtector(t, newTestPassphraseProtector(t))
+	for file, want := range map[string]string{encrypted: `{"access_token":"old"}`, plain: `{"installed":{}}`} {
+		before, _ := os.ReadFile(file)
+		got, err := DecryptFile(file)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: %q, %v", filepath.Base(file), got, err)
+		}
+		if after, _ := os.ReadFile(file); !bytes.Equal(before, after) {
+			t.Errorf("%s перезаписан при чтении", filepath.Base(file))
+		}
+	}
+}
+
+func TestMigrateSecretFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "credentials.json")
+	if err := os.WriteFile(file, []byte(`{"installed":{}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Без схемы шифрования переносить некуда
+	useSecretProtector(t, nil)
+	if ok, err := MigrateSecretFile(file); ok || err != nil {
+		t.Fatalf("без схемы: %v, %v", ok, err)
+	}
+
+	old := newTestAESProtector(t, 1)
+	useSecretProtector(t, old)
+	if ok, err := MigrateSecretFile(file); !ok || err != nil {
+		t.Fatalf("незашифрованный файл: %v, %v", ok, err)
+	}
+	if ok, err := MigrateSecretFile(file); ok || err != nil {
+		t.Errorf("файл текущей схемы перезаписан повторно: %v, %v", ok, err)
+	}
+
+	useSecretProtector(t, newTestPassphraseProtector(t))
+	if ok, err := MigrateSecretFile(file); !ok || err != nil {
+		t.Fatalf("файл другой схемы: %v, %v", ok, err)
+	}
+	content, _ := os.ReadFile(file)
+	if !strings.HasPrefix(string(content), SecretHeaderPrefix+secretHeaderVersion+":"+SchemeAESGCMScrypt+":") {
+		t.Errorf("заголовок после перешифрования: %.40s", content)
+	}
+	if got, err := DecryptFile(file); err != nil || string(got) != `{"installed":{}}` {
+		t.Errorf("после перешифрования: %q, %v", got, err)
+	}
+}
+
+func TestConfigMigrateSecrets(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GDU_TEST_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, aesKeySize)))
+	useSecretProtector(t, nil)
+
+	write := func(name string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	credentialsA, tokenA := write("a.json"), write("a_token.json")
+	credentialsB, tokenB := write("b.json"), write("b_token.json")
+	cfg := &Config{
+		SecretProtection: ConfigSecretProtection{KeyEnv: "GDU_TEST_KEY"},
+		ConfigGoogleDrives: ConfigGoogleDrives{
+			{Id: "a", GoogleCredentialsFile: credentialsA, TokenStore: TokenStoreEncryptedFile},
+			// Токен в незашифрованном файле остаётся как есть, файла токена c нет
+			{Id: "b", GoogleCredentialsFile: credentialsB, TokenStore: TokenStoreFile},
+			{Id: "c", GoogleCredentialsFile: filepath.Join(dir, "c.json")},
+		},
+	}
+
+	migrated, err := cfg.MigrateSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{credentialsA, tokenA, credentialsB}; strings.Join(migrated, ",") != strings.Join(want, ",") {
+		t.Errorf("перешифрованы %v, ожидалось %v", migrated, want)
+	}
+	if content, _ := os.ReadFile(tokenB); string(content) != "b_token.json" {
+		t.Errorf("токен хранилища file изменён: %q", content)
+	}
+	for _, file := range []string{credentialsA, tokenA, credentialsB} {
+		if got, err := DecryptFile(file); err != nil || string(got) != filepath.Base(file) {
+			t.Errorf("%s: %q, %v", filepath.Base(file), got, err)
+		}
+	}
+}
+
+func TestSecretProtectionApplyRequiresScheme(t *testing.T) {
+	if defaultProtector() != nil {
+		t.Skip("в ОС есть схема по умолчанию")
+	}
+	useSecretProtector(t, nil)
+	t.Setenv("GDU_TEST_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, aesKeySize)))
+
+	// Без ключа и явной схемы секреты не записываются открытым текстом молча
+	if err := (&ConfigSecretProtection{}).Apply(); err == nil {
+		t.Error("схема не задана, ожидалась ошибка")
+	}
+	if err := (&ConfigSecretProtection{Scheme: SchemeNone}).Apply(); err != nil || getActiveProtector() != nil {
+		t.Errorf("scheme: none: %v, %v", getActiveProtector(), err)
+	}
+	if err := (&ConfigSecretProtection{KeyEnv: "GDU_TEST_KEY"}).Apply(); err != nil || getActiveProtector().Scheme() != SchemeAESGCM {
+		t.Errorf("ключ задан: %v, %v", getActiveProtector(), err)
+	}
+}
@@ -0,0 +1,272 @@
+package googleupload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// dirParallelismDefault - количество одновременно загружаемых файлов по умолчанию
+const dirParallelismDefault = 4
+
+// DirUploadOptions параметры загрузки каталога
+type DirUploadOptions struct {
+	// Include - шаблоны файлов для загрузки (path.Match), пустой список - все файлы.
+	// Шаблон со "/" сравнивается с путём относительно каталога, иначе - с именем файла
+	Include []string
+	// Exclude - шаблоны исключаемых файлов и каталогов
+	Exclude []string
+	// Parallelism - количество одновременно загружаемых файлов, по умолчанию 4
+	Parallelism int
+}
+
+// DirUploadFailure файл или папка, которые не удалось загрузить
+type DirUploadFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"` // Текст Err для JSON отчёта
+	Err   error  `json:"-"`
+}
+
+// newDirUploadFailure возвращает ошибку загрузки файла или папки path
+func newDirUploadFailure(path string, err error) DirUploadFailure {
+	return DirUploadFailure{Path: path, Error: err.Error(), Err: err}
+}
+
+// DirUploadSummary итог загрузки каталога, пути относительно загружаемого каталога
+type DirUploadSummary struct {
+	CreatedFolders []string           `json:"createdFolders"`
+	UploadedFiles  []string           `json:"uploadedFiles"`
+	Failures       []DirUploadFailure `json:"failures"`
+	Bytes          int64              `json:"bytes"`
+}
+
+// dirEntry файл каталога для загрузки
+type dirEntry struct {
+	rel  string // путь относительно каталога через "/"
+	path string
+	size int64
+}
+
+// UploadDir загружает каталог dir с сохранением иерархии папок в FolderID диска idDisk.
+// Существующие папки с тем же именем используются повторно, для каждого файла выполняется ротация копий
+func (gds *GoogleDisks) UploadDir(ctx context.Context, dir string, idDisk string, opts DirUploadOptions) (*DirUploadSummary, error) {
+	gd, err := gds.findGDById(idDisk)
+	if err != nil {
+		return nil, err
+	}
+	l := slog.With("dir", dir, "idDisk", gd.cfg.Id)
+
+	if err := checkCopiesCount(gd.cfg.UploadCopiesCount); err != nil {
+		return nil, err
+	}
+
+	files, err := collectDirFiles(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &DirUploadSummary{}
+	var totalSize int64
+	for _, f := range files {
+		totalSize += gd.storedSize(f.size)
+	}
+
+	// Место проверяется один раз на весь каталог
+	if _, err := gd.smartClearTrash(ctx, totalSize); err != nil {
+		return nil, err
+	}
+
+	folders := &folderCache{gd: gd, ids: map[string]string{".": gd.cfg.FolderID}}
+
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = dirParallelismDefault
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, parallelism)
+	)
+	for _, f := range files {
+		// Папки создаются последовательно до загрузки файла, чтобы не появились дубликаты
+		folderID, created, err := folders.ensure(ctx, path.Dir(f.rel))
+		mu.Lock()
+		summary.CreatedFolders = append(summary.CreatedFolders, created...)
+		if err != nil {
+			summary.Failures = append(summary.Failures, newDirUploadFailure(f.rel, err))
+		}
+		mu.Unlock()
+		if err != nil {
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return summary, ctx.Err()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := gd.uploadDirFile(ctx, folderID, f)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				l.Warn("ошибка загрузки файла каталога", "file", f.rel, "error", err)
+				summary.Failures = append(summary.Failures, newDirUploadFailure(f.rel, err))
+				return
+			}
+			summary.UploadedFiles = append(summary.UploadedFiles, f.rel)
+			summary.Bytes += f.size
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(summary.UploadedFiles)
+	l.Info("Success upload dir",
+		"createdFolders", len(summary.CreatedFolders),
+		"uploadedFiles", len(summary.UploadedFiles),
+		"failures", len(summary.Failures),
+		"size", FormatBytes(summary.Bytes),
+	)
+	return summary, nil
+}
+
+// uploadDirFile загружает один файл каталога в папку folderID
+func (gd *GoogleDisk) uploadDirFile(ctx context.Context, folderID string, f dirEntry) error {
+	name := gd.storedName(path.Base(f.rel))
+	file, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия файла: %w", err)
+	}
+	defer deferClose("ошибка закрытия файла", file.Close)
+
+	var created *drive.File
+	err = gd.retry.Do(ctx, "upload", func() error {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		media, err := gd.encodeReader(file)
+		if err != nil {
+			return err
+		}
+		defer deferClose("ошибка остановки сжатия", media.Close)
+
+		driveFile := newDriveFile(folderID, name)
+		driveFile.AppProperties = gd.storedProperties(nil, f.size)
+		created, err = gd.backend.CreateFile(ctx, driveFile, gd.throttle(ctx, media))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error upload file: %w", err)
+	}
+	gd.rotateCopies(ctx, folderID, copyFamily{name: name}, gd.cfg.UploadCopiesCount, created.Id)
+	return nil
+}
+
+// folderCache создаёт папки на диске и запоминает их ID по относительному пути
+type folderCache struct {
+	gd  *GoogleDisk
+	ids map[string]string
+}
+
+// ensure возвращает ID папки rel, создавая недостающие папки, и список созданных папок
+func (c *folderCache) ensure(ctx context.Context, rel string) (string, []string, error) {
+	if id, ok := c.ids[rel]; ok {
+		return id, nil, nil
+	}
+
+	parentID, created, err := c.ensure(ctx, path.Dir(rel))
+	if err != nil {
+		return "", created, err
+	}
+
+	name := path.Base(rel)
+	folder, err := c.gd.backend.FindFolder(ctx, parentID, name)
+	if err != nil {
+		return "", created, fmt.Errorf("ошибка поиска папки %s: %w", rel, err)
+	}
+	if folder == nil {
+		folder, err = c.gd.backend.CreateFolder(ctx, parentID, name)
+		if err != nil {
+			return "", created, fmt.Errorf("ошибка создания папки %s: %w", rel, err)
+		}
+		created = append(created, rel)
+	}
+
+	c.ids[rel] = folder.Id
+	return folder.Id, created, nil
+}
+
+// collectDirFiles обходит каталог и возвращает файлы, подходящие под шаблоны
+func collectDirFiles(dir string, opts DirUploadOptions) ([]dirEntry, error) {
+	var files []dirEntry
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+
+		if matchAny(opts.Exclude, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		if len(opts.Include) > 0 && !matchAny(opts.Include, rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, dirEntry{rel: rel, path: p, size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка обхода каталога %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// matchAny проверяет путь rel по шаблонам: шаблон со "/" - по пути, иначе - по имени
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
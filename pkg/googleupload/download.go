@@ -0,0 +1,290 @@
+package googleupload
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// downloadReconnects - сколько раз скачивание продолжается с места обрыва в пределах одного вызова
+const downloadReconnects = 5
+
+// ErrChecksumMismatch - контрольная сумма скачанного файла не совпала с md5Checksum в Drive
+var ErrChecksumMismatch = errors.New("контрольная сумма файла не совпадает")
+
+// PartFileName возвращает имя файла с уже скачанной частью файла fileID.
+// Если такой файл есть, скачивание продолжается с его конца запросом Range
+func PartFileName(dest, fileID string) string {
+	return dest + "." + fileID + ".gdpart"
+}
+
+// Disk возвращает диск по ID, пустой idDisk - диск по умолчанию
+func (gds *GoogleDisks) Disk(idDisk string) (*GoogleDisk, error) {
+	return gds.findGDById(idDisk)
+}
+
+// ListCopies возвращает сохранённые копии файла name в FolderID диска, от новых к старым.
+// При шифровании ищутся копии под зашифрованным именем, при remote_name_template - копии шаблона
+// в его папке
+func (gd *GoogleDisk) ListCopies(ctx context.Context, name string) ([]*drive.File, error) {
+	dir, family := gd.copyFamily(name)
+	folderID, found, err := gd.lookupFolder(ctx, gd.cfg.FolderID, dir)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска копий файла %s: %w", name, err)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	files, err := gd.findCopies(ctx, folderID, family)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска копий файла %s: %w", name, err)
+	}
+	for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+		files[i], files[j] = files[j], files[i]
+	}
+	return files, nil
+}
+
+// DownloadLatest скачивает последнюю копию файла name из FolderID диска в dest
+func (gd *GoogleDisk) DownloadLatest(ctx context.Context, name, dest string) (*drive.File, error) {
+	return gd.DownloadCopy(ctx, name, 0, dest)
+}
+
+// DownloadCopy скачивает копию файла name из FolderID диска в dest.
+// version 0 - последняя копия, 1 - предыдущая и так далее до UploadCopiesCount - 1
+func (gd *GoogleDisk) DownloadCopy(ctx context.Context, name string, version int, dest string) (*drive.File, error) {
+	copies, err := gd.ListCopies(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if version < 0 || version >= len(copies) {
+		return nil, fmt.Errorf("копия %d файла %s не найдена, всего копий: %d", version, name, len(copies))
+	}
+	if dest == "" && gd.encryptor != nil {
+		// Имя в Drive может быть хешем, сохраняем под исходным именем
+		dest = name
+	}
+	return gd.DownloadFile(ctx, copies[version].Id, dest)
+}
+
+// DownloadFile скачивает файл fileID в dest (пустой dest - имя файла в текущем каталоге,
+// существующий каталог - имя файла в этом каталоге). Данные пишутся в PartFileName и
+// переименовываются в dest только после проверки md5Checksum, прерванное скачивание
+// продолжается с места остановки при следующем вызове. Зашифрованный файл расшифровывается
+// ключом шифрования диска, сжатый (appProperties gduCodec) распаковывается, их расширения отбрасываются из имени
+func (gd *GoogleDisk) DownloadFile(ctx context.Context, fileID, dest string) (*drive.File, error) {
+	meta, err := gd.backend.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения информации о файле %s: %w", fileID, err)
+	}
+	if strings.HasPrefix(meta.MimeType, "application/vnd.google-apps.") {
+		return nil, fmt.Errorf("файл %s - документ Google (%s) и не может быть скачан без экспорта", meta.Name, meta.MimeType)
+	}
+
+	if dest == "" || isDir(dest) {
+		localName, err := downloadName(meta)
+		if err != nil {
+			return nil, err
+		}
+		dest = filepath.Join(dest, localName)
+	}
+	l := slog.With("file", dest, "fileId", fileID, "idDisk", gd.cfg.Id)
+	started := time.Now()
+
+	partName := PartFileName(dest, fileID)
+	part, err := os.OpenFile(partName, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания файла: %w", err)
+	}
+	// Повторное закрытие после явного Close ниже возвращает ошибку, её не логируем
+	defer func() { _ = part.Close() }()
+
+	h := md5.New()
+	offset, err := resumePart(part, meta.Size, h)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		l.Info("продолжаем скачивание", "offset", FormatBytes(offset), "total", FormatBytes(meta.Size))
+	}
+
+	w := io.MultiWriter(part, h)
+	// Часть, скачанная целиком, только проверяется: Range за концом файла вернёт 416
+	for reconnects := 0; offset < meta.Size || meta.Size == 0; reconnects++ {
+		var n int64
+		n, err = gd.downloadFrom(ctx, fileID, offset, w)
+		offset += n
+		if err == nil && offset < meta.Size {
+			// Ответ закончился раньше конца файла без ошибки - продолжаем с места остановки, как после обрыва
+			err = fmt.Errorf("получено %d из %d байт: %w", offset, meta.Size, io.ErrUnexpectedEOF)
+		}
+		if err == nil || meta.Size == 0 || ctx.Err() != nil || reconnects >= downloadReconnects {
+			break
+		}
+		l.Warn("обрыв скачивания, продолжаем с места остановки", "offset", FormatBytes(offset), "error", err)
+	}
+	if err != nil {
+		// Скачанная часть остаётся на диске для продолжения
+		return nil, fmt.Errorf("error download file: %w", err)
+	}
+
+	if err := part.Close(); err != nil {
+		return nil, fmt.Errorf("ошибка закрытия файла: %w", err)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if offset != meta.Size || (meta.Md5Checksum != "" && !strings.EqualFold(sum, meta.Md5Checksum)) {
+		if err := os.Remove(partName); err != nil {
+			l.Warn("ошибка удаления скачанной части", "error", err)
+		}
+		return nil, fmt.Errorf("%w: %s, размер %d из %d, md5 %s, ожидался %s",
+			ErrChecksumMismatch, meta.Name, offset, meta.Size, sum, meta.Md5Checksum)
+	}
+
+	// Зашифрованный или сжатый файл расшифровывается и распаковывается в dest,
+	// при ошибке скачанные данные остаются в PartFileName
+	decoded, err := gd.decodeDownload(partName, dest, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	l.Info("Success download file",
+		"fileSize", FormatBytes(meta.Size),
+		"md5", sum,
+		"decoded", decoded,
+		"duration", time.Since(started),
+	)
+	return meta, nil
+}
+
+// downloadName возвращает имя локального файла для файла Drive без расширений шифрования и сжатия.
+// Имя в Drive задаёт владелец файла и может содержать разделители пути, поэтому берётся только последний элемент
+func downloadName(meta *drive.File) (string, error) {
+	name := strings.TrimSuffix(meta.Name, EncryptedExt)
+	if codec := meta.AppProperties[PropCodec]; codec != "" {
+		name = strings.TrimSuffix(name, codecExt[codec])
+	}
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if name == "." || name == ".." || name == "/" || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("недопустимое имя файла %q в Drive, укажите путь для сохранения", meta.Name)
+	}
+	return name, nil
+}
+
+// isDir проверяет, что name - существующий каталог
+func isDir(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+// decodeDownload переносит скачанный файл partName в dest, расшифровывая и распаковывая его при необходимости.
+// Возвращает true, если данные были преобразованы
+func (gd *GoogleDisk) decodeDownload(partName, dest string, meta *drive.File) (bool, error) {
+	part, err := os.Open(partName)
+	if err != nil {
+		return false, fmt.Errorf("ошибка открытия файла: %w", err)
+	}
+	// Повторное закрытие после явного Close ниже возвращает ошибку, её не логируем
+	defer func() { _ = part.Close() }()
+
+	encrypted := IsEncrypted(part)
+	codec := meta.AppProperties[PropCodec]
+	if !encrypted && codec == "" {
+		_ = part.Close()
+		if err := os.Rename(partName, dest); err != nil {
+			return false, fmt.Errorf("ошибка переименования файла: %w", err)
+		}
+		return false, nil
+	}
+
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	var r io.Reader = part
+	if encrypted {
+		if gd.encryptor == nil {
+			return false, fmt.Errorf("%w: для диска %s не задано шифрование", ErrNoDecryptionKey, gd.cfg.Id)
+		}
+		if r, err = gd.encryptor.DecryptReader(part); err != nil {
+			return false, err
+		}
+	}
+	if codec != "" {
+		zr, err := decompressReader(r, codec)
+		if err != nil {
+			return false, fmt.Errorf("ошибка распаковки файла: %w", err)
+		}
+		defer deferClose("ошибка закрытия распаковки", zr.Close)
+		r = zr
+	}
+
+	tmpName := dest + ".gddecode"
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return false, fmt.Errorf("ошибка создания файла: %w", err)
+	}
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return false, fmt.Errorf("ошибка расшифровки или распаковки файла: %w", err)
+	}
+
+	_ = part.Close()
+	if err := os.Rename(tmpName, dest); err != nil {
+		return false, fmt.Errorf("ошибка переименования файла: %w", err)
+	}
+	if err := os.Remove(partName); err != nil {
+		slog.Warn("ошибка удаления скачанной части", "file", partName, "error", err)
+	}
+	return true, nil
+}
+
+// resumePart подготавливает файл с частью данных: хэширует уже скачанное и возвращает смещение.
+// Часть длиннее файла в Drive считается устаревшей и очищается
+func resumePart(part *os.File, size int64, h hash.Hash) (int64, error) {
+	info, err := part.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения информации о файле: %w", err)
+	}
+
+	offset := info.Size()
+	if offset > size {
+		if err := part.Truncate(0); err != nil {
+			return 0, fmt.Errorf("ошибка очистки файла: %w", err)
+		}
+		return 0, nil
+	}
+
+	// После чтения позиция файла - конец скачанной части, запись продолжится с неё
+	if _, err := io.CopyN(h, part, offset); err != nil {
+		return 0, fmt.Errorf("ошибка чтения скачанной части: %w", err)
+	}
+	return offset, nil
+}
+
+// downloadFrom копирует содержимое файла начиная с offset в w и возвращает количество байт
+func (gd *GoogleDisk) downloadFrom(ctx context.Context, fileID string, offset int64, w io.Writer) (int64, error) {
+	body, err := gd.backend.OpenFile(ctx, fileID, offset)
+	if err != nil {
+		return 0, err
+	}
+	defer deferClose("ошибка закрытия ответа", body.Close)
+
+	return io.Copy(w, body)
+}
@@ -0,0 +1,136 @@
+package googleupload
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// shortBodyBackend обрывает ответы OpenFile: первые responses ответов заканчиваются
+// без ошибки после limit байт, как при закрытом сервером соединении
+type shortBodyBackend struct {
+	DriveBackend
+
+	mu        sync.Mutex
+	limit     int64
+	responses int
+	offsets   []int64
+}
+
+func (b *shortBodyBackend) OpenFile(ctx context.Context, fileID string, offset int64) (io.ReadCloser, error) {
+	body, err := b.DriveBackend.OpenFile(ctx, fileID, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.offsets = append(b.offsets, offset)
+	if b.responses == 0 {
+		return body, nil
+	}
+	b.responses--
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(body, b.limit), body}, nil
+}
+
+func TestDownloadResumesShortBody(t *testing.T) {
+	ctx := context.Background()
+	data := []byte(strings.Repeat("0123456789", 100))
+	mem := NewMemoryBackend(1 << 20)
+	file := mem.AddFile("", "db.sql", data)
+
+	// Все попытки одного вызова обрываются: часть остаётся для продолжения
+	backend := &shortBodyBackend{DriveBackend: mem, limit: 100, responses: downloadReconnects + 1}
+	gd := NewGoogleDisk(&ConfigGoogleDrive{Id: "d"}, backend)
+	dest := filepath.Join(t.TempDir(), "restored.sql")
+	if _, err := gd.DownloadFile(ctx, file.Id, dest); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("ожидался обрыв скачивания, получено %v", err)
+	}
+	info, err := os.Stat(PartFileName(dest, file.Id))
+	if err != nil || info.Size() != int64(downloadReconnects+1)*100 {
+		t.Fatalf("скачанная часть: %v, %v", info, err)
+	}
+
+	// Следующий вызов продолжает с конца части, ответ снова короткий, затем полный
+	backend.responses = 1
+	backend.offsets = nil
+	if _, err := gd.DownloadFile(ctx, file.Id, dest); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(dest); err != nil || string(got) != string(data) {
+		t.Fatalf("скачанный файл отличается: %v", err)
+	}
+	if want := []int64{600, 700}; len(backend.offsets) != 2 || backend.offsets[0] != want[0] || backend.offsets[1] != want[1] {
+		t.Errorf("смещения запросов %v, ожидалось %v", backend.offsets, want)
+	}
+	if _, err := os.Stat(PartFileName(dest, file.Id)); !os.IsNotExist(err) {
+		t.Errorf("скачанная часть не удалена: %v", err)
+	}
+}
+
+func TestDownloadChecksumMismatchRemovesPart(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryBackend(1 << 20)
+	file := mem.AddFile("", "db.sql", []byte("backup"))
+
+	dest := filepath.Join(t.TempDir(), "db.sql")
+	// Часть того же размера, но с другим содержимым: продолжать нечего, файл скачивается заново при следующем вызове
+	if err := os.WriteFile(PartFileName(dest, file.Id), []byte("BACKUP"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gd := NewGoogleDisk(&ConfigGoogleDrive{Id: "d"}, mem)
+	if _, err := gd.DownloadFile(ctx, file.Id, dest); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("ожидалось несовпадение контрольной суммы, получено %v", err)
+	}
+	if _, err := os.Stat(PartFileName(dest, file.Id)); !os.IsNotExist(err) {
+		t.Errorf("испорченная часть не удалена: %v", err)
+	}
+	if _, err := gd.DownloadFile(ctx, file.Id, dest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadRemoteNameStaysInDest(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	dest := filepath.Join(root, "restore")
+	if err := os.Mkdir(dest, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	mem := NewMemoryBackend(1 << 20)
+	gd := NewGoogleDisk(&ConfigGoogleDrive{Id: "d"}, mem)
+	for name, want := range map[string]string{
+		"../escape.txt":    "escape.txt",
+		"a/b/nested.txt":   "nested.txt",
+		`..\windows.txt`:   "windows.txt",
+		"/etc/passwd-copy": "passwd-copy",
+	} {
+		file := mem.AddFile("", name, []byte(name))
+		if _, err := gd.DownloadFile(ctx, file.Id, dest); err != nil {
+			t.Errorf("файл %q: %v", name, err)
+			continue
+		}
+		if got, err := os.ReadFile(filepath.Join(dest, want)); err != nil || string(got) != name {
+			t.Errorf("файл %q не сохранён как %s: %v", name, want, err)
+		}
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Errorf("файлы записаны вне каталога назначения: %v", entries)
+	}
+
+	for _, name := range []string{"..", ".", "", "a/.."} {
+		file := mem.AddFile("", name, []byte("x"))
+		if _, err := gd.DownloadFile(ctx, file.Id, dest); err == nil {
+			t.Errorf("файл с именем %q скачан без указания пути", name)
+		}
+	}
+}
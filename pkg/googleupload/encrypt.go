@@ -0,0 +1,584 @@
+package googleupload
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// EncryptionPassphrase - шифрование ключом из пароля, пароль нужен и для загрузки, и для скачивания
+	EncryptionPassphrase = "passphrase"
+	// EncryptionRecipient - шифрование открытыми ключами X25519 получателей: для загрузки достаточно
+	// открытого ключа, расшифровать можно только закрытым
+	EncryptionRecipient = "recipient"
+
+	// EncryptedExt - расширение зашифрованных файлов в Drive
+	EncryptedExt = ".gdenc"
+
+	// RecipientKeyPrefix и IdentityKeyPrefix - префиксы открытого и закрытого ключей X25519 в base64url
+	RecipientKeyPrefix = "gdu-pub-"
+	IdentityKeyPrefix  = "gdu-key-"
+
+	// encMagic - начало заголовка зашифрованного файла
+	encMagic = "GDU-ENC/v1\n"
+	// encChunkSize - размер открытого текста в одной части, каждая часть шифруется отдельно
+	encChunkSize = 64 * 1024
+	// encNameScryptSalt - соль ключа имён файлов для режима с паролем, имена должны совпадать на всех хостах
+	encNameScryptSalt = "gdu-enc-name"
+
+	encModePassphrase byte = 1
+	encModeRecipient  byte = 2
+
+	fileKeySize     = chacha20poly1305.KeySize
+	wrappedKeySize  = fileKeySize + chacha20poly1305.Overhead
+	payloadSaltSize = 16
+	headerMACSize   = sha256.Size
+)
+
+// ErrNoDecryptionKey - файл зашифрован, но ключа для его расшифровки нет
+var ErrNoDecryptionKey = errors.New("нет ключа для расшифровки файла")
+
+// ConfigEncryption шифрование загружаемых файлов на стороне клиента.
+// Зашифрованный файл: заголовок с ключом файла, зашифрованным паролем или ключами получателей,
+// и данные частями по 64 КБ в ChaCha20-Poly1305
+type ConfigEncryption struct {
+	Mode          string   `yaml:"mode" mapstructure:"mode"`                     // passphrase, recipient. Пусто - без шифрования
+	PassphraseEnv string   `yaml:"passphrase_env" mapstructure:"passphrase_env"` // passphrase: переменная окружения с паролем
+	Recipients    []string `yaml:"recipients" mapstructure:"recipients"`         // recipient: открытые ключи gdu-pub-... получателей
+	IdentityFile  string   `yaml:"identity_file" mapstructure:"identity_file"`   // recipient: файл с закрытыми ключами gdu-key-... для расшифровки при скачивании
+	IdentityEnv   string   `yaml:"identity_env" mapstructure:"identity_env"`     // recipient: переменная окружения с закрытым ключом gdu-key-...
+	EncryptNames  bool     `yaml:"encrypt_names" mapstructure:"encrypt_names"`   // Хранить файл в Drive под хешем имени вместо самого имени
+}
+
+// Encryptor возвращает шифрование по конфигурации, nil - шифрование не задано
+func (c ConfigEncryption) Encryptor() (*Encryptor, error) {
+	switch c.Mode {
+	case "", SchemeNone:
+		return nil, nil
+	case EncryptionPassphrase:
+		passphrase := ""
+		if c.PassphraseEnv != "" {
+			passphrase = os.Getenv(c.PassphraseEnv)
+		}
+		if passphrase == "" {
+			return nil, fmt.Errorf("для шифрования passphrase задайте пароль в переменной окружения passphrase_env (%s)", c.PassphraseEnv)
+		}
+		e, err := NewPassphraseEncryptor(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		e.EncryptNames = c.EncryptNames
+		return e, nil
+	case EncryptionRecipient:
+		identities, err := c.loadIdentities()
+		if err != nil {
+			return nil, err
+		}
+		e, err := NewRecipientEncryptor(c.Recipients, identities)
+		if err != nil {
+			return nil, err
+		}
+		e.EncryptNames = c.EncryptNames
+		return e, nil
+	default:
+		return nil, fmt.Errorf("неизвестный режим шифрования: %s", c.Mode)
+	}
+}
+
+// loadIdentities читает закрытые ключи из identity_file (по одному в строке, # - комментарий) и identity_env
+func (c ConfigEncryption) loadIdentities() ([]string, error) {
+	var identities []string
+	if c.IdentityFile != "" {
+		data, err := os.ReadFile(c.IdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения файла ключей %s: %v", c.IdentityFile, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				identities = append(identities, line)
+			}
+		}
+	}
+	if c.IdentityEnv != "" {
+		if identity := strings.TrimSpace(os.Getenv(c.IdentityEnv)); identity != "" {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+// Encryptor шифрует и расшифровывает содержимое файлов потоком
+type Encryptor struct {
+	// EncryptNames - хранить файл под хешем имени. В режиме recipient ключ хеша строится из открытых ключей,
+	// поэтому имя скрыто от просматривающих Drive, но может быть подобрано тем, у кого есть открытый ключ
+	EncryptNames bool
+
+	mode       byte
+	passphrase []byte
+	recipients [][]byte // Открытые ключи X25519
+	identities [][]byte // Закрытые ключи X25519, nil - только шифрование
+	nameKey    []byte
+}
+
+// NewPassphraseEncryptor создаёт шифрование ключом из пароля (scrypt)
+func NewPassphraseEncryptor(passphrase string) (*Encryptor, error) {
+	if passphrase == "" {
+		return nil, errors.New("пустой пароль для шифрования файлов")
+	}
+	nameKey, err := scrypt.Key([]byte(passphrase), []byte(encNameScryptSalt), 1<<15, 8, 1, fileKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryptor{mode: encModePassphrase, passphrase: []byte(passphrase), nameKey: nameKey}, nil
+}
+
+// NewRecipientEncryptor создаёт шифрование открытыми ключами recipients (gdu-pub-...).
+// identities (gdu-key-...) нужны только для расшифровки, их открытые ключи добавляются к получателям
+func NewRecipientEncryptor(recipients, identities []string) (*Encryptor, error) {
+	e := &Encryptor{mode: encModeRecipient}
+	for _, identity := range identities {
+		key, err := decodeKey(identity, IdentityKeyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		e.identities = append(e.identities, key)
+		public, err := curve25519.X25519(key, curve25519.Basepoint)
+		if err != nil {
+			return nil, err
+		}
+		e.recipients = append(e.recipients, public)
+	}
+	for _, recipient := range recipients {
+		key, err := decodeKey(recipient, RecipientKeyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		if !containsKey(e.recipients, key) {
+			e.recipients = append(e.recipients, key)
+		}
+	}
+	if len(e.recipients) == 0 {
+		return nil, errors.New("для шифрования recipient задайте открытые ключи recipients")
+	}
+	if len(e.recipients) > 255 {
+		return nil, errors.New("слишком много получателей, не более 255")
+	}
+
+	// Ключ имён не зависит от порядка получателей в конфигурации
+	sorted := make([]string, 0, len(e.recipients))
+	for _, key := range e.recipients {
+		sorted = append(sorted, string(key))
+	}
+	sort.Strings(sorted)
+	h := sha256.New()
+	h.Write([]byte(encNameScryptSalt))
+	for _, key := range sorted {
+		h.Write([]byte(key))
+	}
+	e.nameKey = h.Sum(nil)
+	return e, nil
+}
+
+// GenerateEncryptionKey создаёт пару ключей X25519 для режима recipient:
+// identity хранится только там, где файлы расшифровываются, recipient указывается в конфигурации хостов резервного копирования
+func GenerateEncryptionKey() (identity, recipient string, err error) {
+	key := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+	public, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		return "", "", err
+	}
+	return IdentityKeyPrefix + base64.RawURLEncoding.EncodeToString(key),
+		RecipientKeyPrefix + base64.RawURLEncoding.EncodeToString(public), nil
+}
+
+func decodeKey(s, prefix string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("ключ должен начинаться с %s", prefix)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, prefix))
+	if err != nil || len(key) != curve25519.PointSize {
+		return nil, fmt.Errorf("неверный ключ %s...: ожидается %d байт в base64url", prefix, curve25519.PointSize)
+	}
+	return key, nil
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoteName возвращает имя зашифрованного файла name в Drive
+func (e *Encryptor) RemoteName(name string) string {
+	if !e.EncryptNames {
+		return name + EncryptedExt
+	}
+	mac := hmac.New(sha256.New, e.nameKey)
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil)[:16]) + EncryptedExt
+}
+
+// EncryptedSize возвращает размер зашифрованного файла по размеру исходного
+func (e *Encryptor) EncryptedSize(size int64) int64 {
+	chunks := max((size+encChunkSize-1)/encChunkSize, 1)
+	return int64(e.headerSize()) + size + chunks*chacha20poly1305.Overhead
+}
+
+func (e *Encryptor) headerSize() int {
+	size := len(encMagic) + 1 + payloadSaltSize + headerMACSize
+	if e.mode == encModePassphrase {
+		return size + saltSize + wrappedKeySize
+	}
+	return size + 1 + len(e.recipients)*(curve25519.PointSize+wrappedKeySize)
+}
+
+// EncryptReader возвращает поток зашифрованных данных r
+func (e *Encryptor) EncryptReader(r io.Reader) (io.Reader, error) {
+	fileKey := make([]byte, fileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	header := bytes.NewBufferString(encMagic)
+	header.WriteByte(e.mode)
+	if e.mode == encModePassphrase {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		wrapKey, err := scrypt.Key(e.passphrase, salt, 1<<15, 8, 1, fileKeySize)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := wrapFileKey(wrapKey, fileKey)
+		if err != nil {
+			return nil, err
+		}
+		header.Write(salt)
+		header.Write(wrapped)
+	} else {
+		header.WriteByte(byte(len(e.recipients)))
+		for _, recipient := range e.recipients {
+			ephemeral := make([]byte, curve25519.ScalarSize)
+			if _, err := rand.Read(ephemeral); err != nil {
+				return nil, err
+			}
+			ephemeralPublic, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+			if err != nil {
+				return nil, err
+			}
+			wrapKey, err := recipientWrapKey(ephemeral, ephemeralPublic, recipient)
+			if err != nil {
+				return nil, err
+			}
+			wrapped, err := wrapFileKey(wrapKey, fileKey)
+			if err != nil {
+				return nil, err
+			}
+			header.Write(ephemeralPublic)
+			header.Write(wrapped)
+		}
+	}
+
+	payloadSalt := make([]byte, payloadSaltSize)
+	if _, err := rand.Read(payloadSalt); err != nil {
+		return nil, err
+	}
+	header.Write(payloadSalt)
+	header.Write(headerMAC(fileKey, header.Bytes()))
+
+	aead, err := payloadAEAD(fileKey, payloadSalt)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptingReader{
+		src:  bufio.NewReaderSize(r, encChunkSize),
+		aead: aead,
+		buf:  make([]byte, encChunkSize),
+		out:  header.Bytes(),
+	}, nil
+}
+
+// DecryptReader возвращает поток расшифрованных данных r.
+// Ошибка ErrNoDecryptionKey - файл зашифрован ключом, которого нет у этого Encryptor
+func (e *Encryptor) DecryptReader(r io.Reader) (io.Reader, error) {
+	src := bufio.NewReaderSize(r, encChunkSize)
+	header := &bytes.Buffer{}
+	read := func(n int) ([]byte, error) {
+		p := make([]byte, n)
+		if _, err := io.ReadFull(src, p); err != nil {
+			return nil, errors.New("заголовок зашифрованного файла повреждён")
+		}
+		header.Write(p)
+		return p, nil
+	}
+
+	magic, err := read(len(encMagic) + 1)
+	if err != nil {
+		return nil, err
+	}
+	if string(magic[:len(encMagic)]) != encMagic {
+		return nil, errors.New("файл не зашифрован или зашифрован неизвестной версией формата")
+	}
+
+	var fileKey []byte
+	switch mode := magic[len(encMagic)]; {
+	case mode == encModePassphrase:
+		stanza, err := read(saltSize + wrappedKeySize)
+		if err != nil {
+			return nil, err
+		}
+		if e.mode != encModePassphrase {
+			return nil, fmt.Errorf("%w: файл зашифрован паролем", ErrNoDecryptionKey)
+		}
+		wrapKey, err := scrypt.Key(e.passphrase, stanza[:saltSize], 1<<15, 8, 1, fileKeySize)
+		if err != nil {
+			return nil, err
+		}
+		if fileKey, err = unwrapFileKey(wrapKey, stanza[saltSize:]); err != nil {
+			return nil, fmt.Errorf("%w: неверный пароль", ErrNoDecryptionKey)
+		}
+	case mode == encModeRecipient:
+		count, err := read(1)
+		if err != nil {
+			return nil, err
+		}
+		for range int(count[0]) {
+			stanza, err := read(curve25519.PointSize + wrappedKeySize)
+			if err != nil {
+				return nil, err
+			}
+			for _, identity := range e.identities {
+				if fileKey != nil {
+					break
+				}
+				wrapKey, err := identityWrapKey(identity, stanza[:curve25519.PointSize])
+				if err != nil {
+					continue
+				}
+				fileKey, _ = unwrapFileKey(wrapKey, stanza[curve25519.PointSize:])
+			}
+		}
+		if fileKey == nil {
+			return nil, fmt.Errorf("%w: ни один закрытый ключ не подходит", ErrNoDecryptionKey)
+		}
+	default:
+		return nil, fmt.Errorf("неизвестный режим шифрования файла: %d", mode)
+	}
+
+	payloadSalt, err := read(payloadSaltSize)
+	if err != nil {
+		return nil, err
+	}
+	mac := make([]byte, headerMACSize)
+	if _, err := io.ReadFull(src, mac); err != nil || !hmac.Equal(mac, headerMAC(fileKey, header.Bytes())) {
+		return nil, errors.New("заголовок зашифрованного файла повреждён или изменён")
+	}
+
+	aead, err := payloadAEAD(fileKey, payloadSalt)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{
+		src:  src,
+		aead: aead,
+		buf:  make([]byte, encChunkSize+chacha20poly1305.Overhead),
+	}, nil
+}
+
+// IsEncrypted проверяет, начинается ли r заголовком зашифрованного файла
+func IsEncrypted(r io.Reader) bool {
+	magic := make([]byte, len(encMagic))
+	_, err := io.ReadFull(r, magic)
+	return err == nil && string(magic) == encMagic
+}
+
+// encryptReader возвращает данные r для загрузки на диск: зашифрованные, если у диска задано шифрование
+func (gd *GoogleDisk) encryptReader(r io.Reader) (io.Reader, error) {
+	if gd.encryptor == nil {
+		return r, nil
+	}
+	return gd.encryptor.EncryptReader(r)
+}
+
+// wrapFileKey шифрует ключ файла ключом wrapKey, который используется один раз, поэтому nonce нулевой
+func wrapFileKey(wrapKey, fileKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+func unwrapFileKey(wrapKey, wrapped []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), wrapped, nil)
+}
+
+// recipientWrapKey - ключ для ключа файла из общего секрета X25519 одноразового ключа и ключа получателя
+func recipientWrapKey(ephemeral, ephemeralPublic, recipient []byte) ([]byte, error) {
+	shared, err := curve25519.X25519(ephemeral, recipient)
+	if err != nil {
+		return nil, err
+	}
+	return deriveKey(shared, append(append([]byte{}, ephemeralPublic...), recipient...), "gdu-enc x25519")
+}
+
+func identityWrapKey(identity, ephemeralPublic []byte) ([]byte, error) {
+	shared, err := curve25519.X25519(identity, ephemeralPublic)
+	if err != nil {
+		return nil, err
+	}
+	public, err := curve25519.X25519(identity, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return deriveKey(shared, append(append([]byte{}, ephemeralPublic...), public...), "gdu-enc x25519")
+}
+
+func headerMAC(fileKey, header []byte) []byte {
+	key, _ := deriveKey(fileKey, nil, "gdu-enc header")
+	mac := hmac.New(sha256.New, key)
+	mac.Write(header)
+	return mac.Sum(nil)
+}
+
+func payloadAEAD(fileKey, salt []byte) (cipher.AEAD, error) {
+	key, err := deriveKey(fileKey, salt, "gdu-enc payload")
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+func deriveKey(secret, salt []byte, info string) ([]byte, error) {
+	key := make([]byte, fileKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// chunkNonce - номер части и признак последней части, так части нельзя переставить или отбросить
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptingReader шифрует данные частями по encChunkSize, последняя часть помечается в nonce
+type encryptingReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	sealed  []byte
+	out     []byte
+	counter uint64
+	done    bool
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.src, r.buf)
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			r.done = true
+		case err != nil:
+			return 0, err
+		default:
+			// Полная часть последняя, если за ней данных нет
+			if _, err := r.src.Peek(1); errors.Is(err, io.EOF) {
+				r.done = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		r.sealed = r.aead.Seal(r.sealed[:0], chunkNonce(r.counter, r.done), r.buf[:n], nil)
+		r.out = r.sealed
+		r.counter++
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// decryptingReader расшифровывает части encryptingReader и проверяет, что файл не обрезан
+type decryptingReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	sealed  []byte
+	out     []byte
+	counter uint64
+	done    bool
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			if _, err := r.src.Peek(1); !errors.Is(err, io.EOF) {
+				return 0, errors.New("лишние данные после конца зашифрованного файла")
+			}
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.src, r.buf)
+		last := false
+		switch {
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			last = true
+		case err != nil:
+			return 0, err
+		default:
+			if _, err := r.src.Peek(1); errors.Is(err, io.EOF) {
+				last = true
+			}
+		}
+
+		r.sealed, err = r.aead.Open(r.sealed[:0], chunkNonce(r.counter, last), r.buf[:n], nil)
+		if err != nil {
+			return 0, errors.New("зашифрованный файл повреждён или обрезан")
+		}
+		r.out = r.sealed
+		r.counter++
+		r.done = last
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
@@ -0,0 +1,369 @@
+package googleupload
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// memoryFile файл в памяти MemoryBackend
+type memoryFile struct {
+	meta        drive.File
+	data        []byte
+	modified    time.Time
+	trashed     bool
+	trashedTime time.Time
+}
+
+// MemoryBackend реализация DriveBackend в памяти для тестов без аккаунта Google.
+// Поддерживает папки-родители, корзину, квоту и сортировку по modifiedTime
+type MemoryBackend struct {
+	mu     sync.Mutex
+	files  map[string]*memoryFile
+	limit  int64
+	nextID int
+	now    time.Time
+
+	// Now возвращает текущее время, по умолчанию time.Now.
+	// Время каждого изменения строго возрастает, даже если Now возвращает одно значение
+	Now func() time.Time
+}
+
+// NewMemoryBackend создаёт пустое хранилище в памяти с квотой limit байт, 0 - без ограничения
+func NewMemoryBackend(limit int64) *MemoryBackend {
+	return &MemoryBackend{
+		files: make(map[string]*memoryFile),
+		limit: limit,
+		Now:   time.Now,
+	}
+}
+
+// tick возвращает строго возрастающее время изменения
+func (m *MemoryBackend) tick() time.Time {
+	now := m.Now()
+	if !now.After(m.now) {
+		now = m.now.Add(time.Millisecond)
+	}
+	m.now = now
+	return now
+}
+
+// AddFile добавляет файл с содержимым data в папку folderID ("" - корень) и возвращает его метаданные
+func (m *MemoryBackend) AddFile(folderID, name string, data []byte) *drive.File {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addFile(&drive.File{Name: name, Parents: parentsOf(folderID)}, data)
+}
+
+func (m *MemoryBackend) addFile(meta *drive.File, data []byte) *drive.File {
+	m.nextID++
+	f := &memoryFile{meta: *meta, modified: m.tick()}
+	f.meta.Id = fmt.Sprintf("mem-%d", m.nextID)
+	f.meta.WebViewLink = "https://drive.google.com/file/d/" + f.meta.Id + "/view"
+	f.setData(data)
+	if len(f.meta.Parents) == 0 {
+		f.meta.Parents = []string{"root"}
+	}
+	m.files[f.meta.Id] = f
+	return f.export()
+}
+
+// TrashFile перемещает файл в корзину
+func (m *MemoryBackend) TrashFile(_ context.Context, fileID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return notFound(fileID)
+	}
+	f.trashed = true
+	f.trashedTime = m.tick()
+	return nil
+}
+
+// Files возвращает все файлы, включая находящиеся в корзине, в порядке modifiedTime
+func (m *MemoryBackend) Files() []*drive.File {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.collect(func(*memoryFile) bool { return true })
+}
+
+// Content возвращает содержимое файла
+func (m *MemoryBackend) Content(fileID string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return nil, notFound(fileID)
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+func (m *MemoryBackend) FindFiles(_ context.Context, folderID, name string) ([]*drive.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent := parentsOf(folderID)[0]
+	return m.collect(func(f *memoryFile) bool {
+		return !f.trashed && f.meta.Name == name && hasParent(&f.meta, parent)
+	}), nil
+}
+
+func (m *MemoryBackend) FindFilesByPrefix(_ context.Context, folderID, prefix string) ([]*drive.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent := parentsOf(folderID)[0]
+	return m.collect(func(f *memoryFile) bool {
+		return !f.trashed && f.meta.MimeType != FolderMimeType && strings.HasPrefix(f.meta.Name, prefix) && hasParent(&f.meta, parent)
+	}), nil
+}
+
+func (m *MemoryBackend) ListTrash(_ context.Context) ([]*drive.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]*memoryFile, 0)
+	for _, f := range m.files {
+		if f.trashed {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].trashedTime.Before(files[j].trashedTime) })
+
+	result := make([]*drive.File, 0, len(files))
+	for _, f := range files {
+		result = append(result, f.export())
+	}
+	return result, nil
+}
+
+func (m *MemoryBackend) DeleteFile(_ context.Context, fileID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[fileID]; !ok {
+		return notFound(fileID)
+	}
+	delete(m.files, fileID)
+	return nil
+}
+
+func (m *MemoryBackend) CreateFile(ctx context.Context, meta *drive.File, media io.Reader, _ ...googleapi.MediaOption) (*drive.File, error) {
+	data, err := io.ReadAll(media)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.limit > 0 && m.usage()+int64(len(data)) > m.limit {
+		return nil, &googleapi.Error{Code: 403, Message: "The user's Drive storage quota has been exceeded."}
+	}
+	return m.addFile(meta, data), nil
+}
+
+func (m *MemoryBackend) FindFolder(_ context.Context, parentID, name string) (*drive.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent := parentsOf(parentID)[0]
+	folders := m.collect(func(f *memoryFile) bool {
+		return !f.trashed && f.meta.MimeType == FolderMimeType && f.meta.Name == name && hasParent(&f.meta, parent)
+	})
+	if len(folders) == 0 {
+		return nil, nil
+	}
+	return folders[0], nil
+}
+
+func (m *MemoryBackend) CreateFolder(_ context.Context, parentID, name string) (*drive.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	folder := newDriveFile(parentID, name)
+	folder.MimeType = FolderMimeType
+	return m.addFile(folder, nil), nil
+}
+
+func (m *MemoryBackend) ListFolder(_ context.Context, folderID string) ([]*drive.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent := parentsOf(folderID)[0]
+	return m.collect(func(f *memoryFile) bool {
+		return !f.trashed && hasParent(&f.meta, parent)
+	}), nil
+}
+
+func (m *MemoryBackend) UpdateFile(ctx context.Context, fileID string, media io.Reader) (*drive.File, error) {
+	data, err := io.ReadAll(media)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return nil, notFound(fileID)
+	}
+	if m.limit > 0 && m.usage()-int64(len(f.data))+int64(len(data)) > m.limit {
+		return nil, &googleapi.Error{Code: 403, Message: "The user's Drive storage quota has been exceeded."}
+	}
+	f.setData(data)
+	f.modified = m.tick()
+	return f.export(), nil
+}
+
+func (m *MemoryBackend) SetAppProperties(_ context.Context, fileID string, props map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return notFound(fileID)
+	}
+	merged := make(map[string]string, len(f.meta.AppProperties)+len(props))
+	for k, v := range f.meta.AppProperties {
+		merged[k] = v
+	}
+	for k, v := range props {
+		merged[k] = v
+	}
+	f.meta.AppProperties = merged
+	return nil
+}
+
+func (m *MemoryBackend) GetFile(_ context.Context, fileID string) (*drive.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return nil, notFound(fileID)
+	}
+	return f.export(), nil
+}
+
+func (m *MemoryBackend) OpenFile(_ context.Context, fileID string, offset int64) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.files[fileID]
+	if !ok {
+		return nil, notFound(fileID)
+	}
+	if offset > int64(len(f.data)) {
+		return nil, &googleapi.Error{Code: http.StatusRequestedRangeNotSatisfiable, Message: "Requested range not satisfiable"}
+	}
+	return io.NopCloser(bytes.NewReader(f.data[offset:])), nil
+}
+
+func (m *MemoryBackend) GetStorageQuota(_ context.Context) (*StorageQuota, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var inTrash int64
+	for _, f := range m.files {
+		if f.trashed {
+			inTrash += int64(len(f.data))
+		}
+	}
+	return newStorageQuota(m.limit, m.usage(), inTrash), nil
+}
+
+// usage возвращает занятое место, файлы в корзине тоже занимают квоту
+func (m *MemoryBackend) usage() int64 {
+	var used int64
+	for _, f := range m.files {
+		used += int64(len(f.data))
+	}
+	return used
+}
+
+// collect возвращает копии метаданных файлов, удовлетворяющих match, в порядке modifiedTime
+func (m *MemoryBackend) collect(match func(*memoryFile) bool) []*drive.File {
+	files := make([]*memoryFile, 0)
+	for _, f := range m.files {
+		if match(f) {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modified.Before(files[j].modified) })
+
+	result := make([]*drive.File, 0, len(files))
+	for _, f := range files {
+		result = append(result, f.export())
+	}
+	return result
+}
+
+// setData заменяет содержимое файла и пересчитывает размер и контрольные суммы
+func (f *memoryFile) setData(data []byte) {
+	f.data = data
+	f.meta.Size = int64(len(data))
+	if f.meta.MimeType != FolderMimeType {
+		md5sum := md5.Sum(data)
+		f.meta.Md5Checksum = hex.EncodeToString(md5sum[:])
+		sha256sum := sha256.Sum256(data)
+		f.meta.Sha256Checksum = hex.EncodeToString(sha256sum[:])
+	}
+}
+
+// export возвращает копию метаданных в формате Drive API
+func (f *memoryFile) export() *drive.File {
+	meta := f.meta
+	meta.Parents = append([]string(nil), f.meta.Parents...)
+	meta.Trashed = f.trashed
+	meta.ModifiedTime = f.modified.UTC().Format(time.RFC3339Nano)
+	meta.CreatedTime = meta.ModifiedTime
+	if f.trashed {
+		meta.TrashedTime = f.trashedTime.UTC().Format(time.RFC3339Nano)
+	}
+	return &meta
+}
+
+func parentsOf(folderID string) []string {
+	if folderID == "" {
+		return []string{"root"}
+	}
+	return []string{folderID}
+}
+
+func hasParent(f *drive.File, parent string) bool {
+	for _, p := range f.Parents {
+		if p == parent {
+			return true
+		}
+	}
+	return false
+}
+
+func notFound(fileID string) error {
+	return &googleapi.Error{Code: 404, Message: "File not found: " + fileID}
+}
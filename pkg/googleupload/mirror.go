@@ -0,0 +1,340 @@
+package googleupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// errAllMirrorsFailed - все загрузки завершились с ошибкой, читать файл дальше незачем
+var errAllMirrorsFailed = errors.New("все загрузки завершились с ошибкой")
+
+// MirrorResult результат загрузки файла на один диск
+type MirrorResult struct {
+	DiskID    string        `json:"diskId"`
+	Success   bool          `json:"success"`
+	FileID    string        `json:"fileId,omitempty"` // ID созданного файла в Drive
+	Bytes     int64         `json:"bytes"`            // Отправлено байт
+	Duration  time.Duration `json:"duration"`
+	Checksums *Checksums    `json:"checksums,omitempty"` // Контрольные суммы, проверенные по ответу Drive
+	Error     string        `json:"error,omitempty"`     // Текст Err для JSON отчёта
+	Err       error         `json:"-"`
+}
+
+// MirrorResults отчёт о зеркальной загрузке по каждому диску
+type MirrorResults []*MirrorResult
+
+// Err возвращает объединённую ошибку по дискам, на которые загрузить файл не удалось
+func (r MirrorResults) Err() error {
+	var errs []error
+	for _, res := range r {
+		if !res.Success {
+			errs = append(errs, fmt.Errorf("disk %s: %w", res.DiskID, res.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MirrorFile загружает один файл на несколько дисков idDisks (пустой список - все включённые диски,
+// повторяющиеся ID загружаются один раз). Файл читается один раз: одновременно загружается не более
+// parallelism дисков (0 - все сразу), им данные раздаются по мере чтения, а для остальных прочитанное
+// сохраняется во временный файл, из которого они загружаются по мере освобождения места.
+// Ошибка на одном диске не прерывает остальные, она попадает в отчёт
+func (gds *GoogleDisks) MirrorFile(ctx context.Context, filename string, idDisks []string, parallelism int) (MirrorResults, error) {
+	disks, err := gds.findGDsById(idDisks)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
+	}
+
+	if parallelism <= 0 || parallelism > len(disks) {
+		parallelism = len(disks)
+	}
+
+	results := gds.mirrorUpload(ctx, filename, fileInfo.Size(), disks, parallelism)
+	for _, res := range results {
+		if res.Success {
+			slog.Info("Success mirror upload", "file", filename, "idDisk", res.DiskID, "fileId", res.FileID, "duration", res.Duration)
+		} else {
+			res.Error = res.Err.Error()
+			slog.Error("ошибка зеркальной загрузки", "file", filename, "idDisk", res.DiskID, "error", res.Err)
+		}
+	}
+	return results, nil
+}
+
+// mirrorUpload загружает файл на диски, читая его один раз, одновременно - не более parallelism дисков
+func (gds *GoogleDisks) mirrorUpload(ctx context.Context, filename string, fileSize int64, disks []*GoogleDisk, parallelism int) MirrorResults {
+	name := filepath.Base(filename)
+	results := make(MirrorResults, len(disks))
+	started := time.Now()
+	slots := make(chan struct{}, parallelism)
+
+	// Подготовка дисков (проверка квоты, затем папка и имя по шаблону), не более parallelism одновременно
+	var (
+		wg        sync.WaitGroup
+		folderIDs = make([]string, len(disks))
+		families  = make([]copyFamily, len(disks))
+	)
+	for i, gd := range disks {
+		results[i] = &MirrorResult{DiskID: gd.cfg.Id}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			o := newUploadOptions(nil)
+			if results[i].Err = checkCopiesCount(gd.cfg.UploadCopiesCount); results[i].Err != nil {
+				return
+			}
+			if _, results[i].Err = gd.prepareUpload(ctx, gd.storedSize(fileSize), o, nil); results[i].Err != nil {
+				return
+			}
+			folderIDs[i], families[i], results[i].Err = gd.uploadTarget(ctx, o, name)
+		}()
+	}
+	wg.Wait()
+
+	// Первые parallelism подготовленных дисков получают данные при чтении файла, остальные ждут в очереди
+	var live, queued []int
+	for i := range disks {
+		switch {
+		case results[i].Err != nil:
+			results[i].Duration = time.Since(started)
+		case len(live) < parallelism:
+			live = append(live, i)
+		default:
+			queued = append(queued, i)
+		}
+	}
+
+	// Каждой загрузке из live - свой pipe, файл раздаётся всем через fanoutWriter
+	fanout := &fanoutWriter{}
+	for _, i := range live {
+		pipeReader, pipeWriter := io.Pipe()
+		fanout.add(pipeWriter)
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			// Данные pipe читаются один раз, повторить такую загрузку нельзя
+			disks[i].mirrorTo(ctx, results[i], folderIDs[i], families[i], func() io.Reader { return pipeReader }, nil, fileSize)
+			// Закрываем pipe, чтобы fanoutWriter перестал отправлять данные в неудавшуюся загрузку
+			_ = pipeReader.CloseWithError(errors.Join(results[i].Err, io.ErrClosedPipe))
+		}()
+	}
+
+	// Для очереди прочитанное сохраняется во временный файл
+	var spool *spoolWriter
+	if len(queued) > 0 {
+		file, err := os.CreateTemp("", "gdu-mirror-*")
+		if err != nil {
+			for _, i := range queued {
+				results[i].Err = fmt.Errorf("ошибка создания временного файла: %w", err)
+			}
+			queued = nil
+		} else {
+			spool = &spoolWriter{file: file}
+			fanout.add(spool)
+			defer func() {
+				deferClose("ошибка закрытия временного файла", file.Close)
+				if err := os.Remove(file.Name()); err != nil {
+					slog.Warn("ошибка удаления временного файла", "file", file.Name(), "error", err)
+				}
+			}()
+		}
+	}
+
+	readErr := fanout.copyFrom(filename)
+	if spool != nil && spool.err == nil {
+		spool.err = readErr
+	}
+
+	for _, i := range queued {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if spool.err != nil {
+				results[i].Err = fmt.Errorf("ошибка сохранения файла во временный: %w", spool.err)
+				results[i].Duration = time.Since(started)
+				return
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results[i].Err = ctx.Err()
+				results[i].Duration = time.Since(started)
+				return
+			}
+			defer func() { <-slots }()
+			spooled := func() io.Reader { return io.NewSectionReader(spool.file, 0, spool.size) }
+			disks[i].mirrorTo(ctx, results[i], folderIDs[i], families[i], spooled, disks[i].retry, fileSize)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// mirrorTo загружает данные файла размером fileSize на диск, подготовленный mirrorUpload, и записывает итог в res.
+// open возвращает данные для каждой попытки: по политике retry повторяются только загрузки,
+// данные которых можно прочитать заново
+func (gd *GoogleDisk) mirrorTo(ctx context.Context, res *MirrorResult, folderID string, family copyFamily, open func() io.Reader, retry *RetryPolicy, fileSize int64) {
+	started := time.Now()
+	defer func() { res.Duration = time.Since(started) }()
+
+	var (
+		driveFile *drive.File
+		sums      *Checksums
+	)
+	err := retry.Do(ctx, "upload", func() error {
+		var err error
+		driveFile, sums, err = gd.mirrorSend(ctx, res, folderID, family, open(), fileSize)
+		return err
+	})
+	if err != nil {
+		res.Err = err
+		return
+	}
+	if err := gd.verifyUpload(ctx, driveFile, sums); err != nil {
+		res.Err = err
+		return
+	}
+	res.Success = true
+	res.Checksums = sums
+	res.FileID = driveFile.Id
+	gd.rotateCopies(ctx, folderID, family, gd.cfg.UploadCopiesCount, driveFile.Id)
+}
+
+// mirrorSend выполняет одну попытку загрузки данных r в папку folderID и возвращает созданный файл
+// и контрольные суммы отправленных данных, отправленные байты записываются в res
+func (gd *GoogleDisk) mirrorSend(ctx context.Context, res *MirrorResult, folderID string, family copyFamily, r io.Reader, fileSize int64) (*drive.File, *Checksums, error) {
+	// Каждый диск сжимает и шифрует данные по своей конфигурации
+	media, err := gd.encodeReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	pr := &progressReader{
+		reader:   gd.throttle(ctx, media),
+		fileSize: gd.storedSize(fileSize),
+		hashes:   newChecksummer(gd.cfg.VerifySHA256),
+	}
+
+	driveFile := newDriveFile(folderID, family.name)
+	driveFile.AppProperties = gd.storedProperties(nil, fileSize)
+	driveFile, err = gd.backend.CreateFile(ctx, driveFile, pr)
+	deferClose("ошибка остановки сжатия", media.Close)
+	res.Bytes = pr.Progress()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error upload file: %w", err)
+	}
+	return driveFile, pr.hashes.Sum(), nil
+}
+
+// spoolWriter временный файл с прочитанными данными для загрузок из очереди
+type spoolWriter struct {
+	file *os.File
+	size int64
+	err  error // ошибка записи или чтения исходного файла, данные для очереди неполные
+}
+
+func (s *spoolWriter) Write(p []byte) (int, error) {
+	n, err := s.file.Write(p)
+	s.size += int64(n)
+	if err != nil {
+		s.err = err
+	}
+	return n, err
+}
+
+// fanoutWriter раздаёт прочитанные данные всем получателям, пропуская завершившиеся с ошибкой
+type fanoutWriter struct {
+	writers []io.Writer
+	failed  []bool
+}
+
+func (f *fanoutWriter) add(w io.Writer) {
+	f.writers = append(f.writers, w)
+	f.failed = append(f.failed, false)
+}
+
+func (f *fanoutWriter) Write(p []byte) (int, error) {
+	alive := 0
+	for i, w := range f.writers {
+		if f.failed[i] {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			f.failed[i] = true
+			continue
+		}
+		alive++
+	}
+	if alive == 0 {
+		return 0, errAllMirrorsFailed
+	}
+	return len(p), nil
+}
+
+// copyFrom читает файл и раздаёт его получателям, по окончании закрывает все pipe.
+// Возвращает ошибку чтения файла
+func (f *fanoutWriter) copyFrom(filename string) error {
+	if len(f.writers) == 0 {
+		return nil
+	}
+	file, err := os.Open(filename)
+	if err == nil {
+		_, err = io.Copy(f, file)
+		deferClose("ошибка закрытия файла", file.Close)
+	}
+	if errors.Is(err, errAllMirrorsFailed) {
+		err = nil
+	}
+	for _, w := range f.writers {
+		if pw, ok := w.(*io.PipeWriter); ok {
+			// nil закрывает pipe с io.EOF, иначе загрузки получат ошибку чтения файла
+			_ = pw.CloseWithError(err)
+		}
+	}
+	return err
+}
+
+// findGDsById возвращает диски по списку ID без повторов, пустой список - все включённые диски
+func (gds *GoogleDisks) findGDsById(idDisks []string) ([]*GoogleDisk, error) {
+	if len(idDisks) == 0 {
+		return gds.ListGoogleDisk, nil
+	}
+
+	disks := make([]*GoogleDisk, 0, len(idDisks))
+	seen := make(map[string]bool, len(idDisks))
+	for _, idDisk := range idDisks {
+		idDisk = strings.TrimSpace(idDisk)
+		if idDisk == "" || seen[idDisk] {
+			continue
+		}
+		seen[idDisk] = true
+		gd, err := gds.findGDById(idDisk)
+		if err != nil {
+			return nil, err
+		}
+		disks = append(disks, gd)
+	}
+	if len(disks) == 0 {
+		return nil, errors.New("no set ID disks for mirror")
+	}
+	return disks, nil
+}
@@ -0,0 +1,167 @@
+package googleupload
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// afterCreateBackend вызывает after после каждой загрузки файла
+type afterCreateBackend struct {
+	DriveBackend
+	after func()
+}
+
+func (b *afterCreateBackend) CreateFile(ctx context.Context, meta *drive.File, media io.Reader, opts ...googleapi.MediaOption) (*drive.File, error) {
+	file, err := b.DriveBackend.CreateFile(ctx, meta, media, opts...)
+	b.after()
+	return file, err
+}
+
+// failFirstCreateBackend читает данные первой загрузки и отвечает на неё временной ошибкой
+type failFirstCreateBackend struct {
+	DriveBackend
+	calls int
+}
+
+func (b *failFirstCreateBackend) CreateFile(ctx context.Context, meta *drive.File, media io.Reader, opts ...googleapi.MediaOption) (*drive.File, error) {
+	b.calls++
+	if b.calls == 1 {
+		_, _ = io.Copy(io.Discard, media)
+		return nil, &googleapi.Error{Code: 503, Message: "backendError"}
+	}
+	return b.DriveBackend.CreateFile(ctx, meta, media, opts...)
+}
+
+func TestMirrorFileRetriesQueuedDisk(t *testing.T) {
+	ctx := context.Background()
+	source := filepath.Join(t.TempDir(), "db.sql")
+	data := strings.Repeat("INSERT INTO t VALUES (1);\n", 1000)
+	if err := os.WriteFile(source, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// При parallelism 1 диск b ждёт в очереди и загружает данные из временного файла
+	memA, memB := NewMemoryBackend(1<<20), NewMemoryBackend(1<<20)
+	backendB := &failFirstCreateBackend{DriveBackend: memB}
+	a := NewGoogleDisk(&ConfigGoogleDrive{Id: "a", Enable: true, UploadCopiesCount: 1}, memA)
+	b := NewGoogleDisk(&ConfigGoogleDrive{Id: "b", Enable: true, UploadCopiesCount: 1}, backendB)
+	b.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, Sleep: func(context.Context, time.Duration) error { return nil }})
+	gds, err := NewGoogleDisks(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := gds.MirrorFile(ctx, source, []string{"a", "b"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := results.Err(); err != nil {
+		t.Fatalf("загрузка из очереди не повторена: %v", err)
+	}
+	for _, res := range results {
+		if res.DiskID != "b" {
+			continue
+		}
+		if content, err := memB.Content(res.FileID); err != nil || string(content) != data {
+			t.Errorf("повторная попытка загрузила %d байт из %d: %v", len(content), len(data), err)
+		}
+	}
+	if backendB.calls != 2 {
+		t.Errorf("попыток загрузки на диск b: %d, ожидалось 2", backendB.calls)
+	}
+}
+
+func TestMirrorFileReadsSourceOnce(t *testing.T) {
+	ctx := context.Background()
+	source := filepath.Join(t.TempDir(), "db.sql")
+	data := strings.Repeat("INSERT INTO t VALUES (1);\n", 10000)
+	if err := os.WriteFile(source, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// После каждой загрузки файл меняется: повторное чтение дало бы дискам разные копии
+	rewrite := func() {
+		if err := os.WriteFile(source, []byte("изменён"), 0o600); err != nil {
+			t.Error(err)
+		}
+	}
+	mems := map[string]*MemoryBackend{}
+	var disks []*GoogleDisk
+	for _, id := range []string{"a", "b", "c"} {
+		mems[id] = NewMemoryBackend(1 << 30)
+		backend := &afterCreateBackend{DriveBackend: mems[id], after: rewrite}
+		disks = append(disks, NewGoogleDisk(&ConfigGoogleDrive{Id: id, Enable: true, UploadCopiesCount: 1}, backend))
+	}
+	gds, err := NewGoogleDisks(disks...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := gds.MirrorFile(ctx, source, []string{"a", "b", "a", " c"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := results.Err(); err != nil || len(results) != 3 {
+		t.Fatalf("результатов %d: %v", len(results), err)
+	}
+	for _, res := range results {
+		content, err := mems[res.DiskID].Content(res.FileID)
+		if err != nil || string(content) != data {
+			t.Errorf("диск %s: загружено %d байт из %d: %v", res.DiskID, len(content), len(data), err)
+		}
+		if len(mems[res.DiskID].Files()) != 1 {
+			t.Errorf("диск %s: файлов %d, повторяющийся ID загружен дважды", res.DiskID, len(mems[res.DiskID].Files()))
+		}
+	}
+	if entries, _ := filepath.Glob(filepath.Join(os.TempDir(), "gdu-mirror-*")); len(entries) != 0 {
+		t.Errorf("временные файлы не удалены: %v", entries)
+	}
+}
+
+func TestMirrorFileReportsErrorInJSON(t *testing.T) {
+	ctx := context.Background()
+	source := filepath.Join(t.TempDir(), "db.sql")
+	if err := os.WriteFile(source, []byte(strings.Repeat("x", 4<<10)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// На диске small не хватает места, остальные загружаются
+	small := NewGoogleDisk(&ConfigGoogleDrive{Id: "small", Enable: true, UploadCopiesCount: 1}, NewMemoryBackend(1<<10))
+	a := NewGoogleDisk(&ConfigGoogleDrive{Id: "a", Enable: true, UploadCopiesCount: 1}, NewMemoryBackend(1<<20))
+	b := NewGoogleDisk(&ConfigGoogleDrive{Id: "b", Enable: true, UploadCopiesCount: 1}, NewMemoryBackend(1<<20))
+	gds, err := NewGoogleDisks(small, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := gds.MirrorFile(ctx, source, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report []struct {
+		DiskID  string `json:"diskId"`
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range report {
+		if res.Success != (res.DiskID != "small") || res.Success != (res.Error == "") {
+			t.Errorf("отчёт по диску %s: %+v", res.DiskID, res)
+		}
+	}
+}
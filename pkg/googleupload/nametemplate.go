@@ -0,0 +1,315 @@
+package googleupload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// NameDateLayoutDefault формат {date} без явного формата
+const NameDateLayoutDefault = "2006-01-02_150405"
+
+// NameTemplate шаблон имени файла в Drive, например "{host}/{base}-{date:2006-01-02_1504}{ext}".
+// Поля: {host} - имя хоста, {name} - имя файла, {base} - имя без расширения, {ext} - расширение с точкой,
+// {date} или {date:формат Go} - время загрузки. Часть до последнего "/" - путь папки, как в UseFolderPath.
+// Копии одного шаблона с разным {date} считаются копиями одного файла при ротации
+type NameTemplate struct {
+	parts []namePart
+
+	// Host значение {host}, по умолчанию имя хоста
+	Host string
+	// Now возвращает время для {date}, по умолчанию time.Now
+	Now func() time.Time
+}
+
+// namePart часть шаблона: текст или поле
+type namePart struct {
+	text   string // Текст, если field пустой
+	field  string
+	layout string // Формат времени для {date}
+}
+
+// ParseNameTemplate разбирает шаблон имени, пустой шаблон - nil без ошибки
+func ParseNameTemplate(tmpl string) (*NameTemplate, error) {
+	if tmpl == "" {
+		return nil, nil
+	}
+
+	t := &NameTemplate{Now: time.Now}
+	rest := tmpl
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, namePart{text: rest})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, namePart{text: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("шаблон имени %q: не закрыта скобка {", tmpl)
+		}
+
+		field, layout, _ := strings.Cut(rest[open+1:open+end], ":")
+		switch field {
+		case "host", "name", "base", "ext":
+			if layout != "" {
+				return nil, fmt.Errorf("шаблон имени %q: у поля {%s} нет формата", tmpl, field)
+			}
+		case "date":
+			if layout == "" {
+				layout = NameDateLayoutDefault
+			}
+			if strings.Contains(layout, "/") {
+				return nil, fmt.Errorf("шаблон имени %q: формат {date} не может содержать /", tmpl)
+			}
+		default:
+			return nil, fmt.Errorf("шаблон имени %q: неизвестное поле {%s}", tmpl, field)
+		}
+		t.parts = append(t.parts, namePart{field: field, layout: layout})
+		rest = rest[open+end+1:]
+	}
+
+	// Ротация копий выполняется в одной папке, поэтому время допустимо только в имени файла
+	dirParts, fileParts := t.split()
+	for _, p := range dirParts {
+		if p.field == "date" {
+			return nil, fmt.Errorf("шаблон имени %q: {date} допустим только в имени файла, не в пути папки", tmpl)
+		}
+	}
+	if len(fileParts) == 0 {
+		return nil, fmt.Errorf("шаблон имени %q: пустое имя файла", tmpl)
+	}
+	// Папки ищутся и создаются по именам, выйти из папки загрузки через ".." нельзя.
+	// Текст рядом с полем, например "..{ext}", - часть имени, а не отдельный сегмент пути
+	for i, p := range t.parts {
+		if p.field != "" {
+			continue
+		}
+		segments := strings.Split(p.text, "/")
+		for j, segment := range segments {
+			whole := (j > 0 || i == 0) && (j < len(segments)-1 || i == len(t.parts)-1)
+			if segment == ".." && whole {
+				return nil, fmt.Errorf("шаблон имени %q: путь не может содержать ..", tmpl)
+			}
+		}
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		t.Host = host
+	}
+	return t, nil
+}
+
+// HasDate сообщает, содержит ли шаблон {date}: имена копий такого шаблона различаются
+func (t *NameTemplate) HasDate() bool {
+	for _, p := range t.parts {
+		if p.field == "date" {
+			return true
+		}
+	}
+	return false
+}
+
+// Execute возвращает путь папки (пустой - без папки, ведущий "/" - от корня диска) и имя файла в Drive для файла name
+func (t *NameTemplate) Execute(name string) (dir, file string) {
+	now := t.Now()
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.field == "date" {
+			b.WriteString(now.Format(p.layout))
+			continue
+		}
+		b.WriteString(t.value(p, name))
+	}
+	dir, file = path.Split(b.String())
+	if dir != "/" {
+		dir = strings.TrimSuffix(dir, "/")
+	}
+	return dir, file
+}
+
+// Family возвращает общее начало имён копий файла name до первого {date} и выражение, которому соответствуют
+// имена всех копий с суффиксом suffix (расширения сжатия и шифрования). nil - копии ищутся по точному имени
+func (t *NameTemplate) Family(name, suffix string) (string, *regexp.Regexp) {
+	if !t.HasDate() {
+		return "", nil
+	}
+
+	_, fileParts := t.split()
+	var (
+		prefix  strings.Builder
+		pattern strings.Builder
+		dated   bool
+	)
+	pattern.WriteString("^")
+	for _, p := range fileParts {
+		if p.field == "date" {
+			dated = true
+			pattern.WriteString(layoutPattern(p.layout))
+			continue
+		}
+		value := t.value(p, name)
+		if !dated {
+			prefix.WriteString(value)
+		}
+		pattern.WriteString(regexp.QuoteMeta(value))
+	}
+	pattern.WriteString(regexp.QuoteMeta(suffix) + "$")
+	return prefix.String(), regexp.MustCompile(pattern.String())
+}
+
+// value возвращает значение поля шаблона для файла name, "/" в значении заменяется на "_"
+func (t *NameTemplate) value(p namePart, name string) string {
+	ext := filepath.Ext(name)
+	var value string
+	switch p.field {
+	case "":
+		return p.text
+	case "host":
+		value = t.Host
+	case "name":
+		value = name
+	case "base":
+		value = strings.TrimSuffix(name, ext)
+	case "ext":
+		value = ext
+	}
+	return strings.ReplaceAll(value, "/", "_")
+}
+
+// split делит шаблон на части пути папки и части имени файла по последнему "/"
+func (t *NameTemplate) split() (dirParts, fileParts []namePart) {
+	for i := len(t.parts) - 1; i >= 0; i-- {
+		p := t.parts[i]
+		if p.field != "" {
+			continue
+		}
+		slash := strings.LastIndexByte(p.text, '/')
+		if slash < 0 {
+			continue
+		}
+		dirParts = append(append(dirParts, t.parts[:i]...), namePart{text: p.text[:slash]})
+		if slash+1 < len(p.text) {
+			fileParts = append(fileParts, namePart{text: p.text[slash+1:]})
+		}
+		return dirParts, append(fileParts, t.parts[i+1:]...)
+	}
+	return nil, t.parts
+}
+
+// layoutPattern возвращает регулярное выражение для времени в формате layout:
+// цифры и буквы (названия месяцев и дней) могут быть любой длины, остальное совпадает точно
+func layoutPattern(layout string) string {
+	sample := time.Date(2006, time.November, 22, 13, 44, 55, 123456789, time.UTC).Format(layout)
+	var b strings.Builder
+	runes := []rune(sample)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		switch r := runes[i]; {
+		case unicode.IsDigit(r) || r == ' ':
+			// Пробел - выравнивание чисел в форматах "_2" и "__2"
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == ' ') {
+				j++
+			}
+			b.WriteString(`[ \d]+`)
+		case unicode.IsLetter(r):
+			for j < len(runes) && unicode.IsLetter(runes[j]) {
+				j++
+			}
+			b.WriteString(`\p{L}+`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// copyFamily копии одного файла в папке, среди которых выполняется ротация
+type copyFamily struct {
+	name   string         // Имя новой копии в Drive
+	prefix string         // Общее начало имён копий для поиска в Drive
+	match  *regexp.Regexp // Имена копий шаблона с {date}, nil - копии с точно таким же именем
+}
+
+// copyFamily возвращает путь папки из шаблона имени и копии файла name в Drive
+// с учётом шаблона имени, сжатия и шифрования диска
+func (gd *GoogleDisk) copyFamily(name string) (string, copyFamily) {
+	if gd.nameTemplate == nil {
+		return "", copyFamily{name: gd.storedName(name)}
+	}
+
+	dir, file := gd.nameTemplate.Execute(name)
+	family := copyFamily{name: gd.storedName(file)}
+	// Хеш имени не сопоставить шаблону, копии ищутся по точному имени
+	if gd.encryptor == nil || !gd.encryptor.EncryptNames {
+		family.prefix, family.match = gd.nameTemplate.Family(name, gd.storedName(""))
+	}
+	return dir, family
+}
+
+// uploadTarget возвращает папку загрузки и копии файла base в Drive с учётом UseRemoteName и шаблона имени.
+// Недостающие папки из UseFolderPath и шаблона создаются
+func (gd *GoogleDisk) uploadTarget(ctx context.Context, o *uploadOptions, base string) (string, copyFamily, error) {
+	dir, family := gd.copyFamily(o.name(base))
+	folderID, err := o.resolveFolder(ctx, gd, dir)
+	if err != nil {
+		return "", copyFamily{}, err
+	}
+	return folderID, family, nil
+}
+
+// findCopies возвращает копии файла в папке folderID, отсортированные по modifiedTime от старых к новым
+func (gd *GoogleDisk) findCopies(ctx context.Context, folderID string, family copyFamily) ([]*drive.File, error) {
+	if family.match == nil {
+		return gd.backend.FindFiles(ctx, folderID, family.name)
+	}
+
+	files, err := gd.backend.FindFilesByPrefix(ctx, folderID, family.prefix)
+	if err != nil {
+		return nil, err
+	}
+	copies := files[:0]
+	for _, f := range files {
+		if family.match.MatchString(f.Name) {
+			copies = append(copies, f)
+		}
+	}
+	return copies, nil
+}
+
+// lookupFolder возвращает ID папки dir в папке folderID без создания папок, false - папки нет
+func (gd *GoogleDisk) lookupFolder(ctx context.Context, folderID, dir string) (string, bool, error) {
+	if strings.HasPrefix(dir, "/") {
+		folderID = ""
+	}
+	dir = path.Clean(strings.Trim(dir, "/"))
+	if dir == "." {
+		return folderID, true, nil
+	}
+	if err := checkFolderPath(dir); err != nil {
+		return "", false, err
+	}
+
+	for _, name := range strings.Split(dir, "/") {
+		folder, err := gd.backend.FindFolder(ctx, folderID, name)
+		if err != nil {
+			return "", false, fmt.Errorf("ошибка поиска папки %s: %w", dir, err)
+		}
+		if folder == nil {
+			return "", false, nil
+		}
+		folderID = folder.Id
+	}
+	return folderID, true, nil
+}
@@ -0,0 +1,171 @@
+package googleupload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNameTemplateRejectsParent(t *testing.T) {
+	for _, tmpl := range []string{"../{name}", "backups/../{name}", "{host}/../../{name}", "{host}/..", "/../{name}"} {
+		if _, err := ParseNameTemplate(tmpl); err == nil {
+			t.Errorf("шаблон %q принят", tmpl)
+		}
+	}
+	for _, tmpl := range []string{"{host}/{name}", "a..b/{base}..{ext}", "./{name}", "/backups/{name}"} {
+		if _, err := ParseNameTemplate(tmpl); err != nil {
+			t.Errorf("шаблон %q: %v", tmpl, err)
+		}
+	}
+}
+
+func TestNameTemplateFamily(t *testing.T) {
+	tmpl, err := ParseNameTemplate("{host}/{base}-{date:2006-01-02_1504}{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Host = "srv/1"
+	tmpl.Now = func() time.Time { return time.Date(2026, time.October, 18, 2, 30, 0, 0, time.UTC) }
+
+	// "/" в значении поля не создаёт вложенную папку
+	if dir, file := tmpl.Execute("db.sql"); dir != "srv_1" || file != "db-2026-10-18_0230.sql" {
+		t.Errorf("Execute: %q, %q", dir, file)
+	}
+
+	prefix, match := tmpl.Family("db.sql", ".gz")
+	if prefix != "db-" {
+		t.Errorf("начало имён копий %q", prefix)
+	}
+	for name, want := range map[string]bool{
+		"db-2026-10-18_0230.sql.gz":     true,
+		"db-2025-01-01_0000.sql.gz":     true,
+		"db-2026-10-18_0230.sql":        false, // другой суффикс: копия без сжатия
+		"db-latest.sql.gz":              false,
+		"db-2026-10-18_0230.sql.gz.bak": false,
+		"dbx-2026-10-18_0230.sql.gz":    false,
+	} {
+		if got := match.MatchString(name); got != want {
+			t.Errorf("%s: совпадение %v, ожидалось %v", name, got, want)
+		}
+	}
+
+	// Без {date} копии ищутся по точному имени
+	plain, err := ParseNameTemplate("{host}/{name}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix, match := plain.Family("db.sql", ""); prefix != "" || match != nil {
+		t.Errorf("шаблон без даты: %q, %v", prefix, match)
+	}
+}
+
+func TestLayoutPattern(t *testing.T) {
+	tests := []struct {
+		layout string
+		match  []string
+		other  []string
+	}{
+		{"2006-01-02_150405", []string{"2026-10-18_023000"}, []string{"2026-10-18", "2026_10_18_023000"}},
+		{"02Jan2006", []string{"05Mar2024", "18Октябрь2026"}, []string{"05-Mar-2024"}},
+		{"Jan _2", []string{"Oct 18", "Feb  3"}, []string{"Feb-3"}},
+		{"2006.01.02", []string{"2026.10.18"}, []string{"2026x10x18"}},
+	}
+	for _, tt := range tests {
+		re := regexp.MustCompile("^" + layoutPattern(tt.layout) + "$")
+		for _, s := range tt.match {
+			if !re.MatchString(s) {
+				t.Errorf("формат %q: %q не совпал с %s", tt.layout, s, re)
+			}
+		}
+		for _, s := range tt.other {
+			if re.MatchString(s) {
+				t.Errorf("формат %q: %q совпал с %s", tt.layout, s, re)
+			}
+		}
+	}
+}
+
+// newTemplateDisk возвращает диск с шаблоном имени, время шаблона увеличивается на минуту при каждом вызове
+func newTemplateDisk(t *testing.T, id, host string, copies int, mem *MemoryBackend) *GoogleDisk {
+	t.Helper()
+	tmpl, err := ParseNameTemplate("{host}/{base}-{date:2006-01-02_1504}{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Host = host
+	now := time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	tmpl.Now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	gd := NewGoogleDisk(&ConfigGoogleDrive{Id: id, UploadCopiesCount: copies, Enable: true}, mem)
+	gd.SetNameTemplate(tmpl)
+	return gd
+}
+
+func TestUploadRotatesCopiesPerHost(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "db.sql")
+	if err := os.WriteFile(filename, []byte("backup"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Два хоста загружают в один аккаунт, у каждого своя папка и свои копии
+	mem := NewMemoryBackend(1 << 20)
+	web := newTemplateDisk(t, "web", "web", 2, mem)
+	db := newTemplateDisk(t, "db", "db", 2, mem)
+	for i := 0; i < 3; i++ {
+		if _, err := web.uploadFile(ctx, filename, newUploadOptions(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.uploadFile(ctx, filename, newUploadOptions(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	webCopies, err := web.ListCopies(ctx, "db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range webCopies {
+		names = append(names, f.Name)
+	}
+	if want := "db-2026-10-18_0003.sql,db-2026-10-18_0002.sql"; strings.Join(names, ",") != want {
+		t.Errorf("копии хоста web: %v, ожидалось %s", names, want)
+	}
+	if dbCopies, err := db.ListCopies(ctx, "db.sql"); err != nil || len(dbCopies) != 1 {
+		t.Errorf("ротация хоста web затронула копии хоста db: %d, %v", len(dbCopies), err)
+	}
+}
+
+func TestUploadQuotaFailureCreatesNoFolders(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "db.sql")
+	if err := os.WriteFile(filename, make([]byte, 4<<10), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// На диске a места нет: загрузка переходит на b, папка {host} на a не создаётся
+	memA, memB := NewMemoryBackend(1<<10), NewMemoryBackend(1<<20)
+	gds, err := NewGoogleDisks(newTemplateDisk(t, "a", "srv", 1, memA), newTemplateDisk(t, "b", "srv", 1, memB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gds.SelectPolicy = SelectRoundRobin
+
+	result, err := gds.Upload(ctx, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DiskID != "b" || len(memA.Files()) != 0 {
+		t.Errorf("загружено на диск %s, файлы на диске a: %v", result.DiskID, fileNames(memA))
+	}
+	if want := []string{"db-2026-10-18_0001.sql", "srv"}; strings.Join(fileNames(memB), ",") != strings.Join(want, ",") {
+		t.Errorf("файлы диска b: %v, ожидалось %v", fileNames(memB), want)
+	}
+}
@@ -0,0 +1,220 @@
+package googleupload
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// UploadOption переопределяет параметры одной загрузки, заданные в ConfigGoogleDrive.
+// example googleupload.Upload(ctx, "test.zip", UseIDDisk("1"), UseCopiesCount(3))
+type UploadOption func(*uploadOptions)
+
+// uploadOptions параметры загрузки, nil и пустые значения - брать из конфигурации диска
+type uploadOptions struct {
+	idDisk           string
+	folderID         *string
+	folderPath       string
+	remoteName       string
+	mimeType         string
+	description      string
+	appProperties    map[string]string
+	copiesCount      *int
+	skipQuotaCheck   bool
+	skipTrashCleanup bool
+	chunkSize        int64
+	sizeHint         int64
+	progress         ProgressFunc
+	reporters        []ProgressReporter
+}
+
+// ProgressFunc получает количество отправленных байт и размер файла
+type ProgressFunc func(uploaded, total int64)
+
+func newUploadOptions(opts []UploadOption) *uploadOptions {
+	o := &uploadOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// UseIDDisk выбирает диск по ID или по имени политики выбора (first-fit, most-free, round-robin)
+func UseIDDisk(idDisk string) UploadOption {
+	return func(o *uploadOptions) { o.idDisk = idDisk }
+}
+
+// UseFolderID загружает файл в папку folderID вместо FolderID диска, пустой folderID - корень диска
+func UseFolderID(folderID string) UploadOption {
+	return func(o *uploadOptions) { o.folderID = &folderID }
+}
+
+// UseFolderPath загружает файл в папку по пути "a/b/c" относительно FolderID диска
+// (или UseFolderID), недостающие папки создаются. Путь с ведущим "/" отсчитывается от корня диска
+func UseFolderPath(folderPath string) UploadOption {
+	return func(o *uploadOptions) { o.folderPath = folderPath }
+}
+
+// UseRemoteName задаёт имя файла в Drive вместо имени локального файла.
+// Ротация копий выполняется по этому имени
+func UseRemoteName(name string) UploadOption {
+	return func(o *uploadOptions) { o.remoteName = name }
+}
+
+// UseMimeType задаёт MIME тип файла в Drive
+func UseMimeType(mimeType string) UploadOption {
+	return func(o *uploadOptions) { o.mimeType = mimeType }
+}
+
+// UseDescription задаёт описание файла в Drive
+func UseDescription(description string) UploadOption {
+	return func(o *uploadOptions) { o.description = description }
+}
+
+// UseAppProperties добавляет свойства приложения (appProperties) к файлу в Drive
+func UseAppProperties(props map[string]string) UploadOption {
+	return func(o *uploadOptions) {
+		if o.appProperties == nil {
+			o.appProperties = make(map[string]string, len(props))
+		}
+		for k, v := range props {
+			o.appProperties[k] = v
+		}
+	}
+}
+
+// UseCopiesCount задаёт количество хранимых копий файла вместо UploadCopiesCount.
+// Если count меньше 1, загрузка завершается ошибкой до отправки данных
+func UseCopiesCount(count int) UploadOption {
+	return func(o *uploadOptions) { o.copiesCount = &count }
+}
+
+// SkipQuotaCheck отключает проверку свободного места и очистку корзины перед загрузкой
+func SkipQuotaCheck() UploadOption {
+	return func(o *uploadOptions) { o.skipQuotaCheck = true }
+}
+
+// SkipTrashCleanup оставляет корзину нетронутой: если места не хватает, загрузка завершается ошибкой
+func SkipTrashCleanup() UploadOption {
+	return func(o *uploadOptions) { o.skipTrashCleanup = true }
+}
+
+// UseChunkSize задаёт размер части загрузки в байтах вместо ChunkSizeMB
+func UseChunkSize(size int64) UploadOption {
+	return func(o *uploadOptions) { o.chunkSize = size }
+}
+
+// UseSizeHint задаёт ожидаемый размер данных для UploadReader: по нему проверяется свободное место
+// и считается прогресс. Без подсказки проверка места пропускается
+func UseSizeHint(size int64) UploadOption {
+	return func(o *uploadOptions) { o.sizeHint = size }
+}
+
+// UseProgress задаёт функцию, которая вызывается по мере отправки данных
+func UseProgress(fn ProgressFunc) UploadOption {
+	return func(o *uploadOptions) { o.progress = fn }
+}
+
+// UseProgressReporter добавляет получателя событий прогресса: этапов загрузки, скорости и ETA.
+// Если получатели не заданы, прогресс пишется в slog раз в минуту
+func UseProgressReporter(r ProgressReporter) UploadOption {
+	return func(o *uploadOptions) { o.reporters = append(o.reporters, r) }
+}
+
+// progressReporters возвращает получателей событий прогресса
+func (o *uploadOptions) progressReporters() []ProgressReporter {
+	if len(o.reporters) == 0 {
+		return []ProgressReporter{defaultProgressReporter}
+	}
+	return o.reporters
+}
+
+// copies возвращает количество хранимых копий для диска gd
+func (o *uploadOptions) copies(gd *GoogleDisk) (int, error) {
+	count := gd.cfg.UploadCopiesCount
+	if o.copiesCount != nil {
+		count = *o.copiesCount
+	}
+	if err := checkCopiesCount(count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// checkCopiesCount проверяет количество хранимых копий: новая копия тоже считается, поэтому меньше 1 быть не может
+func checkCopiesCount(count int) error {
+	if count < 1 {
+		return fmt.Errorf("количество копий должно быть не меньше 1: %d", count)
+	}
+	return nil
+}
+
+// chunkSizeFor возвращает размер части загрузки для диска gd
+func (o *uploadOptions) chunkSizeFor(gd *GoogleDisk) int64 {
+	if o.chunkSize > 0 {
+		return o.chunkSize
+	}
+	return int64(gd.cfg.ChunkSizeMB) * 1024 * 1024
+}
+
+// name возвращает имя файла в Drive для локального файла с именем base
+func (o *uploadOptions) name(base string) string {
+	if o.remoteName != "" {
+		return o.remoteName
+	}
+	return base
+}
+
+// resolveFolder возвращает ID папки загрузки на диске gd, создавая папки из UseFolderPath
+// и вложенной в неё папки subPath (путь с ведущим "/" отсчитывается от корня диска)
+func (o *uploadOptions) resolveFolder(ctx context.Context, gd *GoogleDisk, subPath string) (string, error) {
+	folderID := gd.cfg.FolderID
+	if o.folderID != nil {
+		folderID = *o.folderID
+	}
+
+	folderPath := o.folderPath
+	if strings.HasPrefix(subPath, "/") {
+		folderPath = subPath
+	} else if subPath != "" {
+		folderPath = path.Join(folderPath, subPath)
+	}
+	if strings.HasPrefix(folderPath, "/") {
+		folderID = ""
+	}
+	folderPath = path.Clean(strings.Trim(folderPath, "/"))
+	if folderPath == "." {
+		return folderID, nil
+	}
+	if err := checkFolderPath(folderPath); err != nil {
+		return "", err
+	}
+
+	folders := &folderCache{gd: gd, ids: map[string]string{".": folderID}}
+	id, _, err := folders.ensure(ctx, folderPath)
+	if err != nil {
+		return "", fmt.Errorf("ошибка подготовки папки %s: %w", folderPath, err)
+	}
+	return id, nil
+}
+
+// checkFolderPath возвращает ошибку, если очищенный path.Clean путь папки начинается с "..":
+// в Drive у папки может быть несколько родителей, подняться выше папки загрузки нельзя
+func checkFolderPath(folderPath string) error {
+	if folderPath == ".." || strings.HasPrefix(folderPath, "../") {
+		return fmt.Errorf("путь папки %s выходит за папку загрузки", folderPath)
+	}
+	return nil
+}
+
+// driveFile возвращает метаданные нового файла name в папке folderID с учётом опций
+func (o *uploadOptions) driveFile(folderID, name string) *drive.File {
+	driveFile := newDriveFile(folderID, name)
+	driveFile.MimeType = o.mimeType
+	driveFile.Description = o.description
+	driveFile.AppProperties = o.appProperties
+	return driveFile
+}
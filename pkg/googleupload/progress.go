@@ -0,0 +1,310 @@
+package googleupload
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressPhase этап загрузки файла
+type ProgressPhase string
+
+const (
+	PhaseQuotaCheck ProgressPhase = "quota-check" // Проверка свободного места и, при нехватке, очистка корзины
+	PhaseUploading  ProgressPhase = "uploading"   // Отправка данных
+	PhaseVerifying  ProgressPhase = "verifying"   // Проверка контрольных сумм
+	PhaseCleanup    ProgressPhase = "cleanup"     // Удаление старых копий после загрузки
+	PhaseDone       ProgressPhase = "done"        // Загрузка завершена, Err - результат
+)
+
+const (
+	// progressTickDefault - как часто отправляется событие прогресса во время отправки данных
+	progressTickDefault = time.Second
+	// progressLogIntervalDefault - как часто SlogReporter пишет прогресс в лог
+	progressLogIntervalDefault = time.Minute
+	// progressRateSmoothing - вес нового замера скорости в скользящем среднем
+	progressRateSmoothing = 0.3
+)
+
+// ProgressEvent событие прогресса загрузки одного файла на один диск
+type ProgressEvent struct {
+	DiskID  string        `json:"diskId"`
+	File    string        `json:"file"`
+	Phase   ProgressPhase `json:"phase"`
+	Bytes   int64         `json:"bytes"`   // Отправлено байт
+	Total   int64         `json:"total"`   // Размер файла
+	Rate    float64       `json:"rate"`    // Скорость отправки, байт/с
+	ETA     time.Duration `json:"eta"`     // Оценка оставшегося времени, 0 - неизвестно
+	Elapsed time.Duration `json:"elapsed"` // Время с начала загрузки
+	Err     error         `json:"-"`       // Ошибка загрузки для PhaseDone
+}
+
+// Percent возвращает долю отправленных данных в процентах
+func (e ProgressEvent) Percent() float64 {
+	if e.Total <= 0 {
+		return 0
+	}
+	return float64(e.Bytes) / float64(e.Total) * 100
+}
+
+// ProgressReporter получает события прогресса загрузки.
+// Report вызывается последовательно из одной загрузки, но разные загрузки могут вызывать его параллельно
+type ProgressReporter interface {
+	Report(event ProgressEvent)
+}
+
+// ProgressReporterFunc позволяет использовать функцию как ProgressReporter
+type ProgressReporterFunc func(event ProgressEvent)
+
+func (f ProgressReporterFunc) Report(event ProgressEvent) { f(event) }
+
+// progressTracker отправляет события прогресса одной загрузки.
+// Горутина отправки живёт от начала отправки данных до finish, а не до отмены ctx
+type progressTracker struct {
+	reporters []ProgressReporter
+	event     ProgressEvent
+	started   time.Time
+	tick      time.Duration
+
+	mu       sync.Mutex
+	pr       *progressReader
+	lastTime time.Time
+	lastSent int64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newProgressTracker(diskID, file string, total int64, reporters []ProgressReporter) *progressTracker {
+	return &progressTracker{
+		reporters: reporters,
+		event:     ProgressEvent{DiskID: diskID, File: file, Total: total},
+		started:   time.Now(),
+		tick:      progressTickDefault,
+	}
+}
+
+// phase сообщает о переходе к этапу phase
+func (t *progressTracker) phase(phase ProgressPhase) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.event.Phase = phase
+	t.emit()
+}
+
+// startUpload переходит к отправке данных и периодически сообщает о прогрессе pr
+func (t *progressTracker) startUpload(pr *progressReader) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.pr = pr
+	t.lastTime = time.Now()
+	t.lastSent = pr.Progress()
+	t.event.Phase = PhaseUploading
+	t.emit()
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	t.mu.Unlock()
+
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(t.tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.mu.Lock()
+				t.emit()
+				t.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// stopUpload останавливает периодические события отправки данных
+func (t *progressTracker) stopUpload() {
+	if t == nil || t.stop == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+	t.stop = nil
+}
+
+// finish завершает загрузку событием PhaseDone с результатом err
+func (t *progressTracker) finish(err error) {
+	if t == nil {
+		return
+	}
+	t.stopUpload()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.event.Phase = PhaseDone
+	t.event.Err = err
+	t.event.ETA = 0
+	t.emit()
+}
+
+// emit обновляет счётчики и отправляет событие всем получателям, вызывается под mu
+func (t *progressTracker) emit() {
+	now := time.Now()
+	if t.pr != nil {
+		sent := t.pr.Progress()
+		if dt := now.Sub(t.lastTime).Seconds(); dt > 0 && t.event.Phase == PhaseUploading {
+			rate := float64(sent-t.lastSent) / dt
+			if t.event.Rate == 0 {
+				t.event.Rate = rate
+			} else {
+				t.event.Rate += progressRateSmoothing * (rate - t.event.Rate)
+			}
+		}
+		t.event.Bytes = sent
+		t.lastSent = sent
+		t.lastTime = now
+	}
+
+	t.event.ETA = 0
+	if t.event.Rate > 0 && t.event.Total > t.event.Bytes {
+		t.event.ETA = time.Duration(float64(t.event.Total-t.event.Bytes) / t.event.Rate * float64(time.Second))
+	}
+	t.event.Elapsed = now.Sub(t.started)
+
+	for _, r := range t.reporters {
+		r.Report(t.event)
+	}
+}
+
+// defaultProgressReporter - получатель прогресса, если в загрузке не задан другой
+var defaultProgressReporter ProgressReporter = NewSlogReporter(nil, 0)
+
+// SlogReporter пишет этапы загрузки и прогресс отправки в slog не чаще Interval
+type SlogReporter struct {
+	Logger   *slog.Logger
+	Interval time.Duration
+
+	mu      sync.Mutex
+	lastLog map[string]time.Time
+}
+
+// NewSlogReporter создаёт SlogReporter, nil logger - slog.Default() на момент записи, interval 0 - раз в минуту
+func NewSlogReporter(logger *slog.Logger, interval time.Duration) *SlogReporter {
+	if interval <= 0 {
+		interval = progressLogIntervalDefault
+	}
+	return &SlogReporter{Logger: logger, Interval: interval}
+}
+
+func (r *SlogReporter) Report(e ProgressEvent) {
+	logger := r.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	l := logger.With("file", e.File, "idDisk", e.DiskID)
+	key := e.DiskID + "\x00" + e.File
+
+	switch e.Phase {
+	case PhaseUploading:
+		r.mu.Lock()
+		if r.lastLog == nil {
+			r.lastLog = make(map[string]time.Time)
+		}
+		last, ok := r.lastLog[key]
+		if ok && time.Since(last) < r.Interval {
+			r.mu.Unlock()
+			return
+		}
+		r.lastLog[key] = time.Now()
+		r.mu.Unlock()
+		if !ok {
+			l.Debug("progress phase", "phase", e.Phase)
+			return
+		}
+		l.Info("logProgress upload",
+			"logProgress", fmt.Sprintf("%.2f%%", e.Percent()),
+			"total", FormatBytes(e.Total),
+			"rate", FormatBytes(int64(e.Rate))+"/с",
+			"eta", e.ETA.Round(time.Second),
+		)
+	case PhaseDone:
+		r.mu.Lock()
+		delete(r.lastLog, key)
+		r.mu.Unlock()
+	default:
+		l.Debug("progress phase", "phase", e.Phase)
+	}
+}
+
+// TerminalReporter рисует строку прогресса в терминале, перерисовывая её через "\r"
+type TerminalReporter struct {
+	W     io.Writer
+	Width int // Ширина полосы в символах, по умолчанию 30
+
+	mu sync.Mutex
+}
+
+// NewTerminalReporter создаёт TerminalReporter, выводящий прогресс в w (обычно os.Stderr)
+func NewTerminalReporter(w io.Writer) *TerminalReporter {
+	return &TerminalReporter{W: w, Width: 30}
+}
+
+func (r *TerminalReporter) Report(e ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	width := r.Width
+	if width <= 0 {
+		width = 30
+	}
+	filled := int(e.Percent() / 100 * float64(width))
+	filled = min(max(filled, 0), width)
+
+	line := fmt.Sprintf("\r[%s%s] %6.2f%% %s/%s %s/с ETA %s %-11s",
+		strings.Repeat("#", filled), strings.Repeat("-", width-filled),
+		e.Percent(), FormatBytes(e.Bytes), FormatBytes(e.Total), FormatBytes(int64(e.Rate)),
+		e.ETA.Round(time.Second), e.Phase)
+	if e.Phase == PhaseDone {
+		line += "\n"
+	}
+	_, _ = io.WriteString(r.W, line)
+}
+
+// JSONReporter пишет каждое событие отдельной строкой JSON (JSON Lines)
+type JSONReporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONReporter создаёт JSONReporter, выводящий события в w
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{enc: json.NewEncoder(w)}
+}
+
+// jsonProgressEvent событие в формате JSON: длительности в секундах, ошибка строкой
+type jsonProgressEvent struct {
+	ProgressEvent
+	ETA     float64 `json:"eta"`
+	Elapsed float64 `json:"elapsed"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func (r *JSONReporter) Report(e ProgressEvent) {
+	event := jsonProgressEvent{ProgressEvent: e, ETA: e.ETA.Seconds(), Elapsed: e.Elapsed.Seconds()}
+	if e.Err != nil {
+		event.Error = e.Err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(event); err != nil {
+		slog.Warn("ошибка записи события прогресса", "error", err)
+	}
+}
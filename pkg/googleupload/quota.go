@@ -3,51 +3,52 @@ package googleupload
 import (
 	"context"
 	"fmt"
+	"math"
 )
 
 // StorageQuota содержит информацию о квоте хранилища
 type StorageQuota struct {
-	TotalBytes  int64 `json:"quotaBytesTotal"`       // Общий размер квоты
+	TotalBytes  int64 `json:"quotaBytesTotal"`       // Общий размер квоты, 0 - без ограничения
 	UsedBytes   int64 `json:"quotaBytesUsed"`        // Использованное место
-	FreeBytes   int64 `json:"freeBytesRemaining"`    // Свободное место
+	FreeBytes   int64 `json:"freeBytesRemaining"`    // Свободное место, без ограничения - math.MaxInt64
 	UsedInTrash int64 `json:"quotaBytesUsedInTrash"` // Место в корзине
 }
 
+// newStorageQuota возвращает квоту с лимитом limit, limit <= 0 - без ограничения, как в Drive API
+// для аккаунтов без лимита
+func newStorageQuota(limit, used, usedInTrash int64) *StorageQuota {
+	quota := &StorageQuota{
+		TotalBytes:  max(limit, 0),
+		UsedBytes:   used,
+		UsedInTrash: usedInTrash,
+		FreeBytes:   math.MaxInt64,
+	}
+	if limit > 0 {
+		quota.FreeBytes = limit - used
+	}
+	return quota
+}
+
+// Unlimited сообщает, что квота без ограничения
+func (q *StorageQuota) Unlimited() bool {
+	return q.TotalBytes == 0
+}
+
 // GetStorageQuota получает информацию о квоте хранилища Google Drive
 func (gd *GoogleDisk) GetStorageQuota(ctx context.Context) (*StorageQuota, error) {
-	about, err := gd.Srv.About.Get().Fields("storageQuota").Context(ctx).Do()
+	quota, err := gd.backend.GetStorageQuota(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка получения информации о квоте: %w", err)
 	}
-
-	quota := &StorageQuota{
-		TotalBytes:  about.StorageQuota.Limit,
-		UsedBytes:   about.StorageQuota.Usage,
-		UsedInTrash: about.StorageQuota.UsageInDriveTrash,
-	}
-
-	// Вычисляем свободное место
-	quota.FreeBytes = quota.TotalBytes - quota.UsedBytes
-
 	return quota, nil
 }
 
 // GetStorageQuotaDetailed получает детальную информацию о квоте с дополнительными полями
 func (gd *GoogleDisk) GetStorageQuotaDetailed(ctx context.Context) (*StorageQuota, error) {
-	about, err := gd.Srv.About.Get().Fields("storageQuota").Context(ctx).Do()
+	quota, err := gd.backend.GetStorageQuota(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка получения детальной информации о квоте: %w", err)
 	}
-
-	quota := &StorageQuota{
-		TotalBytes:  about.StorageQuota.Limit,
-		UsedBytes:   about.StorageQuota.Usage,
-		UsedInTrash: about.StorageQuota.UsageInDriveTrash,
-	}
-
-	// Вычисляем свободное место
-	quota.FreeBytes = quota.TotalBytes - quota.UsedBytes
-
 	return quota, nil
 }
 
@@ -58,7 +59,7 @@ func (gd *GoogleDisk) HasEnoughSpace(ctx context.Context, fileSize int64) (bool,
 		return false, nil, err
 	}
 
-	hasSpace := quota.FreeBytes >= fileSize
+	hasSpace := quota.Unlimited() || quota.FreeBytes >= fileSize
 	return hasSpace, quota, nil
 }
 
@@ -0,0 +1,24 @@
+package googleupload
+
+import "time"
+
+// UploadResult результат загрузки файла на диск
+type UploadResult struct {
+	DiskID        string        `json:"diskId"`
+	FileID        string        `json:"fileId"` // ID созданного файла в Drive
+	Name          string        `json:"name"`
+	WebViewLink   string        `json:"webViewLink,omitempty"` // Ссылка на файл в веб-интерфейсе Drive
+	Bytes         int64         `json:"bytes"`
+	Checksums     *Checksums    `json:"checksums"` // Контрольные суммы, проверенные по ответу Drive
+	Duration      time.Duration `json:"duration"`
+	DeletedCopies []DeletedCopy `json:"deletedCopies,omitempty"` // Старые копии, удалённые по UploadCopiesCount
+	TrashFreed    int64         `json:"trashFreedBytes"`         // Место, освобождённое очисткой корзины
+}
+
+// DeletedCopy старая копия файла, удалённая после загрузки новой
+type DeletedCopy struct {
+	FileID       string `json:"fileId"`
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	ModifiedTime string `json:"modifiedTime"`
+}
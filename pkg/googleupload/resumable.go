@@ -0,0 +1,466 @@
+package googleupload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// UploadEndpointDefault - адрес Drive API для загрузки файлов
+	UploadEndpointDefault = "https://www.googleapis.com/upload/drive/v3/files"
+
+	// MinChunkSize - минимальный размер части, размер части должен быть кратен ему
+	MinChunkSize = 256 * 1024
+
+	// statusResumeIncomplete - ответ сервера "308 Resume Incomplete"
+	statusResumeIncomplete = 308
+)
+
+// errSessionExpired - сессия возобновляемой загрузки больше не существует
+var errSessionExpired = errors.New("сессия возобновляемой загрузки истекла")
+
+// UploadState состояние возобновляемой загрузки, сохраняется рядом с исходным файлом
+type UploadState struct {
+	SessionURI string    `json:"sessionUri"` // URI сессии возобновляемой загрузки
+	DiskID     string    `json:"diskId"`     // ID диска, на который идёт загрузка
+	FileSize   int64     `json:"fileSize"`   // Размер исходного файла
+	ModTime    time.Time `json:"modTime"`    // Время изменения исходного файла
+	Offset     int64     `json:"offset"`     // Количество байт, подтверждённых сервером
+}
+
+// ResumableUploader загружает файл по частям через протокол возобновляемой загрузки Drive
+type ResumableUploader struct {
+	Client    *http.Client // HTTP клиент с авторизацией
+	Endpoint  string       // Адрес для загрузки, по умолчанию UploadEndpointDefault
+	ChunkSize int64        // Размер части, округляется вверх до кратного MinChunkSize
+
+	RateLimiters []*RateLimiter // Ограничения скорости отправки, nil - без ограничения
+}
+
+// StateFileName возвращает путь к файлу состояния загрузки filename на диск idDisk
+func StateFileName(filename string, idDisk string) string {
+	return filename + "." + idDisk + ".gdupload"
+}
+
+// Upload загружает файл, продолжая с последнего подтверждённого байта, если есть файл состояния statePath
+func (u *ResumableUploader) Upload(ctx context.Context, meta *drive.File, file *os.File, diskID, statePath string, pr *progressReader) (*drive.File, error) {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
+	}
+
+	state := u.loadState(statePath, diskID, fileInfo)
+	if state != nil {
+		// Узнаём у сервера, сколько байт уже получено
+		offset, done, err := u.queryOffset(ctx, state)
+		switch {
+		case errors.Is(err, errSessionExpired):
+			slog.Warn("сессия загрузки истекла, начинаем заново", "stateFile", statePath)
+			state = nil
+		case err != nil:
+			return nil, err
+		case done != nil:
+			removeState(statePath)
+			if err := pr.hashUpTo(file, state.FileSize); err != nil {
+				return nil, err
+			}
+			return done, nil
+		default:
+			state.Offset = offset
+			slog.Info("продолжаем загрузку", "offset", FormatBytes(offset), "total", FormatBytes(state.FileSize))
+		}
+	}
+
+	if state == nil {
+		if state, err = u.newSession(ctx, meta, fileInfo, diskID, statePath); err != nil {
+			return nil, err
+		}
+	}
+
+	driveFile, err := u.uploadChunks(ctx, file, state, statePath, pr)
+	if errors.Is(err, errSessionExpired) {
+		// Сессия истекла во время отправки: файл отправляется заново в новой сессии.
+		// Контрольные суммы уже посчитанных частей верны, данные файла те же
+		slog.Warn("сессия загрузки истекла во время отправки, начинаем заново", "stateFile", statePath, "offset", FormatBytes(state.Offset))
+		if state, err = u.newSession(ctx, meta, fileInfo, diskID, statePath); err != nil {
+			return nil, err
+		}
+		driveFile, err = u.uploadChunks(ctx, file, state, statePath, pr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	removeState(statePath)
+	return driveFile, nil
+}
+
+// newSession открывает новую сессию загрузки файла и сохраняет её состояние в statePath
+func (u *ResumableUploader) newSession(ctx context.Context, meta *drive.File, fileInfo os.FileInfo, diskID, statePath string) (*UploadState, error) {
+	sessionURI, err := u.startSession(ctx, meta, fileInfo.Size())
+	if err != nil {
+		return nil, err
+	}
+	state := &UploadState{
+		SessionURI: sessionURI,
+		DiskID:     diskID,
+		FileSize:   fileInfo.Size(),
+		ModTime:    fileInfo.ModTime(),
+	}
+	if err := saveState(statePath, state); err != nil {
+		slog.Warn("не удалось сохранить состояние загрузки", "stateFile", statePath, "error", err)
+	}
+	return state, nil
+}
+
+// startSession открывает сессию возобновляемой загрузки и возвращает её URI, size < 0 - размер неизвестен
+func (u *ResumableUploader) startSession(ctx context.Context, meta *drive.File, size int64) (string, error) {
+	body, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := u.Endpoint
+	if endpoint == "" {
+		endpoint = UploadEndpointDefault
+	}
+	query := url.Values{"uploadType": {"resumable"}, "fields": {uploadFields}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	contentType := meta.MimeType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("X-Upload-Content-Type", contentType)
+	if size >= 0 {
+		req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ошибка открытия сессии загрузки: %w", err)
+	}
+	defer deferClose("ошибка закрытия ответа", resp.Body.Close)
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError("ошибка открытия сессии загрузки", resp)
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", errors.New("сервер не вернул URI сессии загрузки")
+	}
+	return location, nil
+}
+
+// queryOffset запрашивает у сервера количество полученных байт.
+// Если загрузка уже завершена, возвращает созданный файл
+func (u *ResumableUploader) queryOffset(ctx context.Context, state *UploadState) (int64, *drive.File, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, state.SessionURI, http.NoBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", state.FileSize))
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("ошибка запроса состояния загрузки: %w", err)
+	}
+	defer deferClose("ошибка закрытия ответа", resp.Body.Close)
+
+	return parseChunkResponse(resp)
+}
+
+// uploadChunks отправляет файл частями начиная с state.Offset, сохраняя прогресс после каждой части
+func (u *ResumableUploader) uploadChunks(ctx context.Context, file *os.File, state *UploadState, statePath string, pr *progressReader) (*drive.File, error) {
+	buf := make([]byte, u.chunkSize())
+
+	for {
+		pr.setUploaded(state.Offset)
+
+		n, err := file.ReadAt(buf, state.Offset)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("ошибка чтения файла: %w", err)
+		}
+
+		body := throttleReader(ctx, bytes.NewReader(buf[:n]), u.RateLimiters...)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, state.SessionURI, body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(n)
+		if n == 0 {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", state.FileSize))
+		} else {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", state.Offset, state.Offset+int64(n)-1, state.FileSize))
+		}
+
+		resp, err := u.Client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка отправки части файла: %w", err)
+		}
+		offset, driveFile, err := parseChunkResponse(resp)
+		deferClose("ошибка закрытия ответа", resp.Body.Close)
+		if err != nil {
+			return nil, err
+		}
+		if driveFile != nil {
+			pr.setUploaded(state.FileSize)
+			if err := pr.hashUpTo(file, state.FileSize); err != nil {
+				return nil, err
+			}
+			return driveFile, nil
+		}
+
+		// Контрольные суммы считаются по подтверждённым сервером данным
+		if err := pr.hashUpTo(file, offset); err != nil {
+			return nil, err
+		}
+		state.Offset = offset
+		if err := saveState(statePath, state); err != nil {
+			slog.Warn("не удалось сохранить состояние загрузки", "stateFile", statePath, "error", err)
+		}
+	}
+}
+
+// UploadStream загружает данные r неизвестного размера частями "bytes a-b/*", размер сообщается с последней частью.
+// Отправленная, но не подтверждённая сервером часть хранится в памяти и при временной ошибке
+// отправляется повторно по политике retry. Продолжение после перезапуска невозможно: данные r уже прочитаны
+func (u *ResumableUploader) UploadStream(ctx context.Context, meta *drive.File, r io.Reader, pr *progressReader, retry *RetryPolicy) (*drive.File, error) {
+	sessionURI, err := u.startSession(ctx, meta, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := u.chunkSize()
+	buf := make([]byte, chunkSize)
+	var (
+		start   int64 // Смещение первого байта pending в потоке
+		pending []byte
+		eof     bool
+	)
+
+	for {
+		// Дополняем часть новыми данными до chunkSize
+		if !eof && len(pending) < len(buf) {
+			n, err := io.ReadFull(r, buf[len(pending):])
+			if pr.hashes != nil {
+				_, _ = pr.hashes.Write(buf[len(pending) : len(pending)+n])
+			}
+			pending = buf[:len(pending)+n]
+			switch {
+			case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+				eof = true
+			case err != nil:
+				return nil, fmt.Errorf("ошибка чтения данных: %w", err)
+			}
+		}
+
+		total := "*"
+		if eof {
+			total = strconv.FormatInt(start+int64(len(pending)), 10)
+		}
+
+		var (
+			offset    int64
+			driveFile *drive.File
+			attempt   int
+		)
+		err := retry.Do(ctx, "uploadChunk", func() error {
+			var err error
+			attempt++
+			if attempt > 1 {
+				// После ошибки сервер мог получить часть данных, досылаем только остаток
+				var acked int64
+				acked, driveFile, err = u.queryStreamOffset(ctx, sessionURI, total)
+				if err != nil || driveFile != nil {
+					return err
+				}
+				if pending, err = trimAcked(pending, start, acked); err != nil {
+					return err
+				}
+				start = acked
+			}
+
+			offset, driveFile, err = u.sendStreamChunk(ctx, sessionURI, pending, start, total)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if driveFile != nil {
+			pr.setUploaded(start + int64(len(pending)))
+			return driveFile, nil
+		}
+		if eof && len(pending) == 0 {
+			return nil, errors.New("сервер не завершил загрузку после получения всех данных")
+		}
+
+		// Неподтверждённый остаток части переносим в начало буфера
+		rest, err := trimAcked(pending, start, offset)
+		if err != nil {
+			return nil, err
+		}
+		pending = buf[:copy(buf, rest)]
+		start = offset
+		pr.setUploaded(start)
+	}
+}
+
+// sendStreamChunk отправляет data со смещения start, total - размер потока или "*", если он ещё неизвестен
+func (u *ResumableUploader) sendStreamChunk(ctx context.Context, sessionURI string, data []byte, start int64, total string) (int64, *drive.File, error) {
+	body := throttleReader(ctx, bytes.NewReader(data), u.RateLimiters...)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, sessionURI, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.ContentLength = int64(len(data))
+	if len(data) == 0 {
+		req.Header.Set("Content-Range", "bytes */"+total)
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", start, start+int64(len(data))-1, total))
+	}
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("ошибка отправки части файла: %w", err)
+	}
+	defer deferClose("ошибка закрытия ответа", resp.Body.Close)
+	return parseChunkResponse(resp)
+}
+
+// queryStreamOffset запрашивает у сервера количество полученных байт потока
+func (u *ResumableUploader) queryStreamOffset(ctx context.Context, sessionURI, total string) (int64, *drive.File, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, sessionURI, http.NoBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Range", "bytes */"+total)
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("ошибка запроса состояния загрузки: %w", err)
+	}
+	defer deferClose("ошибка закрытия ответа", resp.Body.Close)
+	return parseChunkResponse(resp)
+}
+
+// trimAcked отбрасывает из data, начинающейся со смещения start, байты до подтверждённого сервером acked
+func trimAcked(data []byte, start, acked int64) ([]byte, error) {
+	if acked < start || acked > start+int64(len(data)) {
+		return nil, fmt.Errorf("сервер подтвердил неожиданное смещение %d, ожидалось от %d до %d", acked, start, start+int64(len(data)))
+	}
+	return data[acked-start:], nil
+}
+
+// chunkSize возвращает размер части, кратный MinChunkSize
+func (u *ResumableUploader) chunkSize() int64 {
+	chunkSize := u.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = MinChunkSize
+	}
+	if chunkSize%MinChunkSize != 0 {
+		chunkSize += MinChunkSize - chunkSize%MinChunkSize
+	}
+	return chunkSize
+}
+
+// parseChunkResponse разбирает ответ на отправку части: 308 - загрузка не завершена, 200/201 - файл создан
+func parseChunkResponse(resp *http.Response) (int64, *drive.File, error) {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		driveFile := &drive.File{}
+		if err := json.NewDecoder(resp.Body).Decode(driveFile); err != nil {
+			return 0, nil, fmt.Errorf("ошибка разбора ответа сервера: %w", err)
+		}
+		return 0, driveFile, nil
+	case statusResumeIncomplete:
+		offset, err := parseRangeHeader(resp.Header.Get("Range"))
+		return offset, nil, err
+	case http.StatusNotFound, http.StatusGone:
+		return 0, nil, errSessionExpired
+	default:
+		return 0, nil, responseError("ошибка загрузки части файла", resp)
+	}
+}
+
+// parseRangeHeader возвращает следующий байт для отправки по заголовку "Range: bytes=0-N"
+func parseRangeHeader(header string) (int64, error) {
+	if header == "" {
+		// Сервер ещё не получил ни одного байта
+		return 0, nil
+	}
+	_, last, ok := strings.Cut(strings.TrimPrefix(header, "bytes="), "-")
+	if !ok {
+		return 0, fmt.Errorf("неверный заголовок Range: %s", header)
+	}
+	n, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("неверный заголовок Range: %s", header)
+	}
+	return n + 1, nil
+}
+
+// responseError формирует ошибку из неуспешного HTTP ответа
+// как *googleapi.Error, чтобы по коду и причине можно было решить, повторять ли запрос
+func responseError(msg string, resp *http.Response) error {
+	err := googleapi.CheckResponse(resp)
+	if err == nil {
+		err = errors.New(resp.Status)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// loadState загружает состояние загрузки, если оно относится к тому же файлу и диску
+func (u *ResumableUploader) loadState(statePath, diskID string, fileInfo os.FileInfo) *UploadState {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil
+	}
+
+	state := &UploadState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		slog.Warn("повреждён файл состояния загрузки", "stateFile", statePath, "error", err)
+		return nil
+	}
+
+	// Если файл изменился с прошлого запуска - начинаем заново
+	if state.DiskID != diskID || state.FileSize != fileInfo.Size() || !state.ModTime.Equal(fileInfo.ModTime()) {
+		slog.Info("исходный файл изменился, загрузка начнётся заново", "stateFile", statePath)
+		return nil
+	}
+	return state
+}
+
+// saveState сохраняет состояние загрузки в файл
+func saveState(statePath string, state *UploadState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(statePath, data, 0600)
+}
+
+// removeState удаляет файл состояния после завершения загрузки
+func removeState(statePath string) {
+	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+		slog.Warn("не удалось удалить файл состояния загрузки", "stateFile", statePath, "error", err)
+	}
+}
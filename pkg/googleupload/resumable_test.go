@@ -0,0 +1,362 @@
+package googleupload
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// fakeUploadServer - httptest замена Drive для протокола возобновляемой загрузки:
+// сессия по POST, части по PUT с Content-Range, 308 с заголовком Range до получения всех данных
+type fakeUploadServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	sessions map[string]*fakeSession
+	puts     []string // Content-Range всех PUT запросов по порядку
+	posts    int
+
+	// hook вызывается для каждого PUT до обработки, true - ответ уже отправлен хуком
+	hook func(w http.ResponseWriter, r *http.Request, put int, s *fakeSession) bool
+}
+
+type fakeSession struct {
+	name  string
+	total int64 // -1 - размер неизвестен
+	data  []byte
+}
+
+func newFakeUploadServer(t *testing.T) *fakeUploadServer {
+	f := &fakeUploadServer{sessions: map[string]*fakeSession{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeUploadServer) uploader() *ResumableUploader {
+	return &ResumableUploader{Client: f.Client(), Endpoint: f.URL + "/upload", ChunkSize: MinChunkSize}
+}
+
+func (f *fakeUploadServer) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodPost {
+		meta := &drive.File{}
+		_ = json.NewDecoder(r.Body).Decode(meta)
+		total := int64(-1)
+		if v := r.Header.Get("X-Upload-Content-Length"); v != "" {
+			total, _ = strconv.ParseInt(v, 10, 64)
+		}
+		f.posts++
+		id := strconv.Itoa(f.posts)
+		f.sessions[id] = &fakeSession{name: meta.Name, total: total}
+		w.Header().Set("Location", f.URL+"/session/"+id)
+		return
+	}
+
+	s, ok := f.sessions[strings.TrimPrefix(r.URL.Path, "/session/")]
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	contentRange := r.Header.Get("Content-Range")
+	f.puts = append(f.puts, contentRange)
+	if f.hook != nil && f.hook(w, r, len(f.puts), s) {
+		return
+	}
+
+	spec, total, _ := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	if spec != "*" {
+		first, _, _ := strings.Cut(spec, "-")
+		start, _ := strconv.ParseInt(first, 10, 64)
+		body, _ := io.ReadAll(r.Body)
+		if start != int64(len(s.data)) {
+			http.Error(w, fmt.Sprintf("unexpected offset %d, have %d", start, len(s.data)), http.StatusBadRequest)
+			return
+		}
+		s.data = append(s.data, body...)
+	}
+	if total != "*" {
+		s.total, _ = strconv.ParseInt(total, 10, 64)
+	}
+
+	if s.total >= 0 && int64(len(s.data)) == s.total {
+		sum := md5.Sum(s.data)
+		_ = json.NewEncoder(w).Encode(&drive.File{
+			Id: "file-" + s.name, Name: s.name, Size: s.total, Md5Checksum: hex.EncodeToString(sum[:]),
+		})
+		return
+	}
+	if len(s.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.data)-1))
+	}
+	w.WriteHeader(statusResumeIncomplete)
+}
+
+// session возвращает единственную сессию с данными, полученными сервером
+func (f *fakeUploadServer) session(t *testing.T, id string) *fakeSession {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.sessions[id]
+	if !ok {
+		t.Fatalf("нет сессии %s", id)
+	}
+	return s
+}
+
+func writeRandomFile(t *testing.T, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	name := filepath.Join(t.TempDir(), "backup.bin")
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name, data
+}
+
+func uploadFromState(t *testing.T, u *ResumableUploader, filename, statePath string) (*drive.File, *progressReader, error) {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, _ := file.Stat()
+	pr := &progressReader{fileSize: info.Size(), hashes: newChecksummer(false)}
+	driveFile, err := u.Upload(context.Background(), &drive.File{Name: "backup.bin"}, file, "1", statePath, pr)
+	return driveFile, pr, err
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestResumableUploadResumesAfterInterruption(t *testing.T) {
+	srv := newFakeUploadServer(t)
+	filename, data := writeRandomFile(t, 3*MinChunkSize+100)
+	statePath := StateFileName(filename, "1")
+
+	// Вторая часть обрывается ошибкой сервера, первая уже подтверждена
+	srv.hook = func(w http.ResponseWriter, _ *http.Request, put int, _ *fakeSession) bool {
+		if put == 2 {
+			http.Error(w, "backend error", http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	}
+	if _, _, err := uploadFromState(t, srv.uploader(), filename, statePath); err == nil {
+		t.Fatal("ожидалась ошибка прерванной загрузки")
+	}
+
+	stateData, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("файл состояния не сохранён: %v", err)
+	}
+	state := &UploadState{}
+	if err := json.Unmarshal(stateData, state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Offset != MinChunkSize {
+		t.Fatalf("offset в состоянии = %d, ожидалось %d", state.Offset, MinChunkSize)
+	}
+
+	srv.hook = nil
+	driveFile, pr, err := uploadFromState(t, srv.uploader(), filename, statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if srv.posts != 1 {
+		t.Errorf("открыто сессий: %d, ожидалась одна", srv.posts)
+	}
+	// После перезапуска сначала запрашивается состояние, затем отправка продолжается со второй части
+	wantPuts := []string{
+		fmt.Sprintf("bytes 0-%d/%d", MinChunkSize-1, len(data)),
+		fmt.Sprintf("bytes %d-%d/%d", MinChunkSize, 2*MinChunkSize-1, len(data)),
+		fmt.Sprintf("bytes */%d", len(data)),
+		fmt.Sprintf("bytes %d-%d/%d", MinChunkSize, 2*MinChunkSize-1, len(data)),
+		fmt.Sprintf("bytes %d-%d/%d", 2*MinChunkSize, 3*MinChunkSize-1, len(data)),
+		fmt.Sprintf("bytes %d-%d/%d", 3*MinChunkSize, len(data)-1, len(data)),
+	}
+	if strings.Join(srv.puts, "\n") != strings.Join(wantPuts, "\n") {
+		t.Errorf("запросы PUT:\n%s\nожидалось:\n%s", strings.Join(srv.puts, "\n"), strings.Join(wantPuts, "\n"))
+	}
+	if !bytes.Equal(srv.session(t, "1").data, data) {
+		t.Error("сервер получил данные, отличные от файла")
+	}
+	if got := pr.hashes.Sum().MD5; got != md5Hex(data) || got != driveFile.Md5Checksum {
+		t.Errorf("md5 отправленных данных %s, файла %s, Drive %s", got, md5Hex(data), driveFile.Md5Checksum)
+	}
+	if pr.Progress() != int64(len(data)) {
+		t.Errorf("прогресс %d, ожидалось %d", pr.Progress(), len(data))
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Error("файл состояния не удалён после завершения загрузки")
+	}
+}
+
+func TestResumableUploadStaleState(t *testing.T) {
+	tests := []struct {
+		name  string
+		state func(filename string) string
+	}{
+		{
+			name: "сессия истекла",
+			state: func(filename string) string {
+				info, _ := os.Stat(filename)
+				return mustJSON(&UploadState{SessionURI: "/session/expired", DiskID: "1", FileSize: info.Size(), ModTime: info.ModTime(), Offset: MinChunkSize})
+			},
+		},
+		{
+			name: "файл изменился",
+			state: func(filename string) string {
+				info, _ := os.Stat(filename)
+				return mustJSON(&UploadState{SessionURI: "/session/old", DiskID: "1", FileSize: info.Size(), ModTime: info.ModTime().Add(-time.Hour), Offset: MinChunkSize})
+			},
+		},
+		{
+			name: "другой диск",
+			state: func(filename string) string {
+				info, _ := os.Stat(filename)
+				return mustJSON(&UploadState{SessionURI: "/session/old", DiskID: "2", FileSize: info.Size(), ModTime: info.ModTime(), Offset: MinChunkSize})
+			},
+		},
+		{
+			name:  "повреждённый файл",
+			state: func(string) string { return "{not json" },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeUploadServer(t)
+			filename, data := writeRandomFile(t, 2*MinChunkSize+1)
+			statePath := StateFileName(filename, "1")
+			state := strings.ReplaceAll(tt.state(filename), "/session/", srv.URL+"/session/")
+			if err := os.WriteFile(statePath, []byte(state), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			driveFile, pr, err := uploadFromState(t, srv.uploader(), filename, statePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if srv.posts != 1 {
+				t.Errorf("открыто сессий: %d, ожидалась новая сессия", srv.posts)
+			}
+			if !bytes.Equal(srv.session(t, "1").data, data) {
+				t.Error("новая сессия получила не весь файл")
+			}
+			if driveFile.Md5Checksum != pr.hashes.Sum().MD5 {
+				t.Errorf("md5 Drive %s, отправлено %s", driveFile.Md5Checksum, pr.hashes.Sum().MD5)
+			}
+			if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+				t.Error("устаревший файл состояния не удалён")
+			}
+		})
+	}
+}
+
+func TestResumableUploadSessionExpiresMidUpload(t *testing.T) {
+	srv := newFakeUploadServer(t)
+	filename, data := writeRandomFile(t, 3*MinChunkSize)
+
+	// После первой части сессия 1 пропадает: вторая часть получает 404
+	srv.hook = func(w http.ResponseWriter, r *http.Request, put int, _ *fakeSession) bool {
+		if put == 2 {
+			_, _ = io.Copy(io.Discard, r.Body)
+			http.Error(w, "session not found", http.StatusNotFound)
+			return true
+		}
+		return false
+	}
+
+	driveFile, pr, err := uploadFromState(t, srv.uploader(), filename, StateFileName(filename, "1"))
+	if err != nil {
+		t.Fatalf("загрузка не продолжена в новой сессии: %v", err)
+	}
+	if srv.posts != 2 {
+		t.Errorf("открыто сессий: %d, ожидалось две", srv.posts)
+	}
+	if !bytes.Equal(srv.session(t, "2").data, data) {
+		t.Error("новая сессия получила не весь файл")
+	}
+	if driveFile.Md5Checksum != md5Hex(data) || pr.hashes.Sum().MD5 != md5Hex(data) {
+		t.Errorf("md5 Drive %s, отправлено %s, файла %s", driveFile.Md5Checksum, pr.hashes.Sum().MD5, md5Hex(data))
+	}
+}
+
+func TestResumableUpload308WithoutRange(t *testing.T) {
+	srv := newFakeUploadServer(t)
+	filename, data := writeRandomFile(t, 2*MinChunkSize)
+
+	// Первая часть теряется: сервер отвечает 308 без Range, то есть не получил ни одного байта
+	srv.hook = func(w http.ResponseWriter, r *http.Request, put int, _ *fakeSession) bool {
+		if put == 1 {
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.WriteHeader(statusResumeIncomplete)
+			return true
+		}
+		return false
+	}
+
+	driveFile, pr, err := uploadFromState(t, srv.uploader(), filename, StateFileName(filename, "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := fmt.Sprintf("bytes 0-%d/%d", MinChunkSize-1, len(data))
+	if len(srv.puts) != 3 || srv.puts[0] != first || srv.puts[1] != first {
+		t.Errorf("первая часть должна быть отправлена повторно, запросы: %q", srv.puts)
+	}
+	if !bytes.Equal(srv.session(t, "1").data, data) {
+		t.Error("сервер получил данные, отличные от файла")
+	}
+	if driveFile.Md5Checksum != md5Hex(data) || pr.hashes.Sum().MD5 != md5Hex(data) {
+		t.Errorf("md5 Drive %s, отправлено %s, файла %s", driveFile.Md5Checksum, pr.hashes.Sum().MD5, md5Hex(data))
+	}
+}
+
+func TestUploadStreamUnknownSize(t *testing.T) {
+	srv := newFakeUploadServer(t)
+	data := bytes.Repeat([]byte("stream"), MinChunkSize/2)
+
+	pr := &progressReader{fileSize: -1, hashes: newChecksummer(false)}
+	driveFile, err := srv.uploader().UploadStream(context.Background(), &drive.File{Name: "db.sql"}, bytes.NewReader(data), pr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(srv.puts[0], "/*") {
+		t.Errorf("размер потока не должен передаваться до последней части: %q", srv.puts[0])
+	}
+	if !bytes.Equal(srv.session(t, "1").data, data) || driveFile.Md5Checksum != md5Hex(data) {
+		t.Error("сервер получил данные, отличные от потока")
+	}
+}
+
+func mustJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
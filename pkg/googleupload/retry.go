@@ -0,0 +1,245 @@
+package googleupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// ConfigRetry параметры повтора запросов к Drive при временных ошибках
+type ConfigRetry struct {
+	MaxAttempts int           `yaml:"max_attempts" mapstructure:"max_attempts" default:"5"` // Всего попыток, 1 - без повторов
+	BaseDelay   time.Duration `yaml:"base_delay" mapstructure:"base_delay" default:"1s"`    // Задержка перед первым повтором, далее удваивается
+	MaxDelay    time.Duration `yaml:"max_delay" mapstructure:"max_delay" default:"32s"`     // Наибольшая задержка
+	Jitter      float64       `yaml:"jitter" mapstructure:"jitter" default:"0.2"`           // Случайное отклонение задержки, доля от 0 до 1
+}
+
+// Policy возвращает политику повторов по конфигурации
+func (c ConfigRetry) Policy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: c.MaxAttempts,
+		BaseDelay:   c.BaseDelay,
+		MaxDelay:    c.MaxDelay,
+		Jitter:      c.Jitter,
+	}
+}
+
+// Validate проверяет параметры повтора
+func (c ConfigRetry) Validate() error {
+	switch {
+	case c.MaxAttempts < 1:
+		return fmt.Errorf("retry.max_attempts должно быть не меньше 1: %d", c.MaxAttempts)
+	case c.BaseDelay < 0 || c.MaxDelay < 0:
+		return errors.New("retry: задержка не может быть отрицательной")
+	case c.Jitter < 0 || c.Jitter > 1:
+		return fmt.Errorf("retry.jitter должно быть от 0 до 1: %v", c.Jitter)
+	}
+	return nil
+}
+
+// retryableReasons - причины ошибок 403, после которых запрос стоит повторить
+var retryableReasons = map[string]bool{
+	"userRateLimitExceeded": true,
+	"rateLimitExceeded":     true,
+	"backendError":          true,
+	"internalError":         true,
+}
+
+// RetryPolicy повторяет запросы с экспоненциальной задержкой при временных ошибках.
+// nil политика выполняет запрос один раз
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+
+	// Retryable решает, повторять ли запрос после ошибки, по умолчанию IsRetryable
+	Retryable func(err error) bool
+	// Sleep ждёт d или отмены ctx, по умолчанию таймер
+	Sleep func(ctx context.Context, d time.Duration) error
+	// Rand возвращает случайное число [0, 1) для отклонения задержки, по умолчанию rand.Float64
+	Rand func() float64
+}
+
+// Do выполняет fn, повторяя её после временных ошибок. op - название операции для логов
+func (p *RetryPolicy) Do(ctx context.Context, op string, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	sleep := p.Sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		slog.Warn("временная ошибка Drive, повторяем запрос",
+			"op", op, "attempt", attempt, "maxAttempts", p.MaxAttempts, "delay", delay, "error", err)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// delay возвращает задержку перед повтором после попытки attempt
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		random := p.Rand
+		if random == nil {
+			random = rand.Float64
+		}
+		d = time.Duration(float64(d) * (1 - p.Jitter + 2*p.Jitter*random()))
+	}
+	return d
+}
+
+// IsRetryable проверяет, временная ли ошибка: 429, 5xx, превышение лимита запросов,
+// обрыв или сброс соединения
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == http.StatusTooManyRequests, apiErr.Code >= 500:
+			return true
+		case apiErr.Code == http.StatusForbidden:
+			for _, item := range apiErr.Errors {
+				if retryableReasons[item.Reason] {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryBackend повторяет запросы DriveBackend по политике.
+// Создание и обновление файлов не повторяются: данные media уже могли быть прочитаны,
+// их повторяет загрузка целиком, открывая файл заново. Перед повтором создания папки она ищется
+type retryBackend struct {
+	next   DriveBackend
+	policy *RetryPolicy
+}
+
+// newRetryBackend оборачивает backend политикой повторов, nil политика - backend без изменений
+func newRetryBackend(backend DriveBackend, policy *RetryPolicy) DriveBackend {
+	if policy == nil {
+		return backend
+	}
+	return &retryBackend{next: backend, policy: policy}
+}
+
+// retryValue выполняет запрос, возвращающий значение, с повторами
+func retryValue[T any](ctx context.Context, p *RetryPolicy, op string, fn func() (T, error)) (T, error) {
+	var result T
+	err := p.Do(ctx, op, func() error {
+		var err error
+		result, err = fn()
+		return err
+	})
+	return result, err
+}
+
+func (b *retryBackend) FindFiles(ctx context.Context, folderID, name string) ([]*drive.File, error) {
+	return retryValue(ctx, b.policy, "FindFiles", func() ([]*drive.File, error) { return b.next.FindFiles(ctx, folderID, name) })
+}
+
+func (b *retryBackend) FindFilesByPrefix(ctx context.Context, folderID, prefix string) ([]*drive.File, error) {
+	return retryValue(ctx, b.policy, "FindFilesByPrefix", func() ([]*drive.File, error) { return b.next.FindFilesByPrefix(ctx, folderID, prefix) })
+}
+
+func (b *retryBackend) ListTrash(ctx context.Context) ([]*drive.File, error) {
+	return retryValue(ctx, b.policy, "ListTrash", func() ([]*drive.File, error) { return b.next.ListTrash(ctx) })
+}
+
+func (b *retryBackend) DeleteFile(ctx context.Context, fileID string) error {
+	return b.policy.Do(ctx, "DeleteFile", func() error { return b.next.DeleteFile(ctx, fileID) })
+}
+
+func (b *retryBackend) CreateFile(ctx context.Context, meta *drive.File, media io.Reader, opts ...googleapi.MediaOption) (*drive.File, error) {
+	return b.next.CreateFile(ctx, meta, media, opts...)
+}
+
+func (b *retryBackend) GetStorageQuota(ctx context.Context) (*StorageQuota, error) {
+	return retryValue(ctx, b.policy, "GetStorageQuota", func() (*StorageQuota, error) { return b.next.GetStorageQuota(ctx) })
+}
+
+func (b *retryBackend) FindFolder(ctx context.Context, parentID, name string) (*drive.File, error) {
+	return retryValue(ctx, b.policy, "FindFolder", func() (*drive.File, error) { return b.next.FindFolder(ctx, parentID, name) })
+}
+
+// CreateFolder повторяет создание папки, но перед повтором ищет её: если ответ на предыдущую попытку
+// не дошёл, а папка уже создана, повтор создал бы дубликат
+func (b *retryBackend) CreateFolder(ctx context.Context, parentID, name string) (*drive.File, error) {
+	attempt := 0
+	return retryValue(ctx, b.policy, "CreateFolder", func() (*drive.File, error) {
+		attempt++
+		if attempt > 1 {
+			folder, err := b.next.FindFolder(ctx, parentID, name)
+			if err != nil || folder != nil {
+				return folder, err
+			}
+		}
+		return b.next.CreateFolder(ctx, parentID, name)
+	})
+}
+
+func (b *retryBackend) ListFolder(ctx context.Context, folderID string) ([]*drive.File, error) {
+	return retryValue(ctx, b.policy, "ListFolder", func() ([]*drive.File, error) { return b.next.ListFolder(ctx, folderID) })
+}
+
+func (b *retryBackend) UpdateFile(ctx context.Context, fileID string, media io.Reader) (*drive.File, error) {
+	return b.next.UpdateFile(ctx, fileID, media)
+}
+
+func (b *retryBackend) TrashFile(ctx context.Context, fileID string) error {
+	return b.policy.Do(ctx, "TrashFile", func() error { return b.next.TrashFile(ctx, fileID) })
+}
+
+func (b *retryBackend) SetAppProperties(ctx context.Context, fileID string, props map[string]string) error {
+	return b.policy.Do(ctx, "SetAppProperties", func() error { return b.next.SetAppProperties(ctx, fileID, props) })
+}
+
+func (b *retryBackend) GetFile(ctx context.Context, fileID string) (*drive.File, error) {
+	return retryValue(ctx, b.policy, "GetFile", func() (*drive.File, error) { return b.next.GetFile(ctx, fileID) })
+}
+
+func (b *retryBackend) OpenFile(ctx context.Context, fileID string, offset int64) (io.ReadCloser, error) {
+	return retryValue(ctx, b.policy, "OpenFile", func() (io.ReadCloser, error) { return b.next.OpenFile(ctx, fileID, offset) })
+}
@@ -0,0 +1,279 @@
+package googleupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// scriptedDrive - httptest замена Drive API: запросы отвечают по очереди заданными ошибками,
+// после того как очередь исчерпана - handler
+type scriptedDrive struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	script   []scriptedError
+	requests []string
+	handler  func(w http.ResponseWriter, r *http.Request)
+}
+
+// scriptedError ответ с ошибкой Drive API; before выполняется до ответа, например, чтобы изобразить
+// запрос, выполненный сервером, ответ на который потерян
+type scriptedError struct {
+	code   int
+	reason string
+	before func()
+}
+
+func newScriptedDrive(t *testing.T, handler func(w http.ResponseWriter, r *http.Request), script ...scriptedError) *scriptedDrive {
+	d := &scriptedDrive{script: script, handler: handler}
+	d.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		d.requests = append(d.requests, r.Method+" "+r.URL.Path)
+		var next *scriptedError
+		if len(d.script) > 0 {
+			next = &d.script[0]
+			d.script = d.script[1:]
+		}
+		d.mu.Unlock()
+
+		if next == nil {
+			d.handler(w, r)
+			return
+		}
+		if next.before != nil {
+			next.before()
+		}
+		writeJSON(w, next.code, map[string]any{"error": map[string]any{
+			"code":    next.code,
+			"message": http.StatusText(next.code),
+			"errors":  []map[string]string{{"reason": next.reason, "message": http.StatusText(next.code)}},
+		}})
+	}))
+	t.Cleanup(d.Close)
+	return d
+}
+
+// Requests возвращает выполненные запросы в виде "METHOD path"
+func (d *scriptedDrive) Requests() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.requests...)
+}
+
+// backend возвращает driveBackend поверх сервера с политикой повторов без задержек, паузы записываются в sleeps
+func (d *scriptedDrive) backend(t *testing.T, sleeps *[]time.Duration) DriveBackend {
+	t.Helper()
+	srv, err := drive.NewService(context.Background(), option.WithHTTPClient(d.Client()), option.WithEndpoint(d.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   time.Second,
+		MaxDelay:    3 * time.Second,
+		Sleep: func(_ context.Context, delay time.Duration) error {
+			*sleeps = append(*sleeps, delay)
+			return nil
+		},
+	}
+	return newRetryBackend(NewDriveBackend(srv), policy)
+}
+
+// fileListHandler отвечает на любой запрос списком из одного файла
+func fileListHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"files": []map[string]string{{"id": "file-1", "name": "db.sql"}}})
+}
+
+func TestRetryBackendRetriesTransientErrors(t *testing.T) {
+	d := newScriptedDrive(t, fileListHandler,
+		scriptedError{code: http.StatusServiceUnavailable, reason: "backendError"},
+		scriptedError{code: http.StatusTooManyRequests, reason: "rateLimitExceeded"},
+		scriptedError{code: http.StatusForbidden, reason: "userRateLimitExceeded"},
+	)
+	var sleeps []time.Duration
+	files, err := d.backend(t, &sleeps).FindFiles(context.Background(), "folder", "db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Id != "file-1" {
+		t.Errorf("файлы %v", files)
+	}
+	if len(d.Requests()) != 4 {
+		t.Errorf("запросы %v, ожидалось 4", d.Requests())
+	}
+	// Задержка удваивается и ограничена MaxDelay
+	if want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}; fmt.Sprint(sleeps) != fmt.Sprint(want) {
+		t.Errorf("задержки %v, ожидалось %v", sleeps, want)
+	}
+}
+
+func TestRetryBackendGivesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   []scriptedError
+		requests int
+	}{
+		{
+			name:     "постоянная ошибка",
+			script:   []scriptedError{{code: http.StatusNotFound, reason: "notFound"}},
+			requests: 1,
+		},
+		{
+			name:     "403 без превышения лимита",
+			script:   []scriptedError{{code: http.StatusForbidden, reason: "insufficientPermissions"}},
+			requests: 1,
+		},
+		{
+			name: "попытки исчерпаны",
+			script: []scriptedError{
+				{code: http.StatusInternalServerError}, {code: http.StatusBadGateway},
+				{code: http.StatusServiceUnavailable}, {code: http.StatusGatewayTimeout},
+			},
+			requests: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newScriptedDrive(t, func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) }, tt.script...)
+			var sleeps []time.Duration
+			err := d.backend(t, &sleeps).DeleteFile(context.Background(), "file-1")
+
+			var apiErr *googleapi.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != tt.script[len(tt.script)-1].code {
+				t.Errorf("ожидалась ошибка %d, получено %v", tt.script[len(tt.script)-1].code, err)
+			}
+			if got := len(d.Requests()); got != tt.requests || len(sleeps) != tt.requests-1 {
+				t.Errorf("запросов %d, пауз %d, ожидалось %d запросов", got, len(sleeps), tt.requests)
+			}
+		})
+	}
+}
+
+func TestRetryBackendDoesNotRetryUploads(t *testing.T) {
+	d := newScriptedDrive(t, fileListHandler, scriptedError{code: http.StatusServiceUnavailable, reason: "backendError"})
+	var sleeps []time.Duration
+	_, err := d.backend(t, &sleeps).CreateFile(context.Background(), newDriveFile("folder", "db.sql"), strings.NewReader("data"))
+	if err == nil || len(d.Requests()) != 1 || len(sleeps) != 0 {
+		t.Errorf("создание файла: %v, запросы %v, паузы %v", err, d.Requests(), sleeps)
+	}
+}
+
+// folderDrive изображает папки Drive для CreateFolder и FindFolder
+type folderDrive struct {
+	mu      sync.Mutex
+	folders []string
+}
+
+func (f *folderDrive) create() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.folders = append(f.folders, fmt.Sprintf("folder-%d", len(f.folders)+1))
+}
+
+func (f *folderDrive) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		f.create()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodPost {
+		writeJSON(w, http.StatusOK, map[string]string{"id": f.folders[len(f.folders)-1], "name": "backups"})
+		return
+	}
+	files := make([]map[string]string, 0, len(f.folders))
+	for _, id := range f.folders {
+		files = append(files, map[string]string{"id": id, "name": "backups"})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"files": files})
+}
+
+func TestRetryBackendCreateFolderNoDuplicate(t *testing.T) {
+	folders := &folderDrive{}
+	// Drive создаёт папку, но ответ теряется
+	d := newScriptedDrive(t, folders.handle, scriptedError{code: http.StatusServiceUnavailable, reason: "backendError", before: folders.create})
+
+	var sleeps []time.Duration
+	folder, err := d.backend(t, &sleeps).CreateFolder(context.Background(), "parent", "backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.Id != "folder-1" || len(folders.folders) != 1 {
+		t.Errorf("папка %s, папок на диске %v", folder.Id, folders.folders)
+	}
+	if want := "POST /files,GET /files"; strings.Join(d.Requests(), ",") != want {
+		t.Errorf("запросы %v, ожидалось %s", d.Requests(), want)
+	}
+}
+
+func TestRetryBackendCreateFolderRetriesWhenNotCreated(t *testing.T) {
+	folders := &folderDrive{}
+	// Запрос отклонён до создания папки: повтор после поиска создаёт её
+	d := newScriptedDrive(t, folders.handle, scriptedError{code: http.StatusTooManyRequests, reason: "rateLimitExceeded"})
+
+	var sleeps []time.Duration
+	folder, err := d.backend(t, &sleeps).CreateFolder(context.Background(), "parent", "backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if folder.Id != "folder-1" || len(folders.folders) != 1 {
+		t.Errorf("папка %s, папок на диске %v", folder.Id, folders.folders)
+	}
+	if want := "POST /files,GET /files,POST /files"; strings.Join(d.Requests(), ",") != want {
+		t.Errorf("запросы %v, ожидалось %s", d.Requests(), want)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{context.Canceled, false},
+		{fmt.Errorf("upload: %w", context.DeadlineExceeded), false},
+		{&googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{&googleapi.Error{Code: http.StatusBadGateway}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true},
+		{&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "storageQuotaExceeded"}}}, false},
+		{&googleapi.Error{Code: http.StatusNotFound}, false},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{errors.New("другая ошибка"), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, ожидалось %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 0.5}
+	for _, tt := range []struct {
+		attempt int
+		random  float64
+		want    time.Duration
+	}{
+		{1, 0, 500 * time.Millisecond},
+		{1, 0.5, time.Second},
+		{3, 0.75, 5 * time.Second},
+		{10, 0, 5 * time.Second},
+	} {
+		p.Rand = func() float64 { return tt.random }
+		if got := p.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) при rand %v = %s, ожидалось %s", tt.attempt, tt.random, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package googleupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SelectPolicy политика выбора диска для загрузки
+type SelectPolicy string
+
+const (
+	// SelectFirstFit - первый по порядку в конфигурации диск, на котором хватает места
+	SelectFirstFit SelectPolicy = "first-fit"
+	// SelectMostFree - диск с наибольшим свободным местом
+	SelectMostFree SelectPolicy = "most-free"
+	// SelectRoundRobin - диски по очереди
+	SelectRoundRobin SelectPolicy = "round-robin"
+)
+
+// ParseSelectPolicy возвращает политику по имени
+func ParseSelectPolicy(name string) (SelectPolicy, bool) {
+	switch policy := SelectPolicy(strings.ToLower(name)); policy {
+	case SelectFirstFit, SelectMostFree, SelectRoundRobin:
+		return policy, true
+	}
+	return "", false
+}
+
+// selectPolicyFor определяет, нужно ли выбирать диск по политике для idDisk
+func (gds *GoogleDisks) selectPolicyFor(idDisk string) (SelectPolicy, bool) {
+	if idDisk == "" {
+		return gds.SelectPolicy, gds.SelectPolicy != ""
+	}
+
+	// ID диска имеет приоритет над именем политики
+	for _, gd := range gds.ListGoogleDisk {
+		if gd.cfg.Id == idDisk {
+			return "", false
+		}
+	}
+	return ParseSelectPolicy(idDisk)
+}
+
+// uploadWithFailover загружает файл на диск, выбранный по политике.
+// Если проверка квоты или загрузка не удалась, пробует следующий диск
+func (gds *GoogleDisks) uploadWithFailover(ctx context.Context, filename string, policy SelectPolicy, o *uploadOptions) (*UploadResult, error) {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
+	}
+
+	candidates, err := gds.selectDisks(ctx, fileInfo.Size(), policy)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, gd := range candidates {
+		result, err := gd.uploadFile(ctx, filename, o)
+		if err == nil {
+			slog.Info("файл загружен на диск, выбранный по политике", "file", filename, "idDisk", gd.cfg.Id, "policy", policy)
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		slog.Warn("не удалось загрузить файл, пробуем следующий диск", "file", filename, "idDisk", gd.cfg.Id, "policy", policy, "error", err)
+		errs = append(errs, fmt.Errorf("disk %s: %w", gd.cfg.Id, err))
+	}
+
+	return nil, fmt.Errorf("не удалось загрузить файл ни на один диск: %w", errors.Join(errs...))
+}
+
+// diskSpace свободное место на диске, ok - удалось ли получить квоту, fits - хватает ли места для файла
+type diskSpace struct {
+	gd   *GoogleDisk
+	free int64
+	ok   bool
+	fits bool
+}
+
+// selectDisks возвращает диски в порядке попыток загрузки для политики, fileSize - размер исходного файла или 0, если неизвестен.
+// Место на каждом диске сравнивается с размером файла в Drive с учётом его сжатия и шифрования
+func (gds *GoogleDisks) selectDisks(ctx context.Context, fileSize int64, policy SelectPolicy) ([]*GoogleDisk, error) {
+	disks := gds.ListGoogleDisk
+	if len(disks) == 0 {
+		return nil, errors.New("no set config_google_drives")
+	}
+
+	if policy == SelectRoundRobin {
+		// Каждая следующая загрузка начинает со следующего диска
+		start := int((gds.roundRobin.Add(1) - 1) % uint64(len(disks)))
+		return append(disks[start:len(disks):len(disks)], disks[:start]...), nil
+	}
+
+	spaces := make([]diskSpace, 0, len(disks))
+	for _, gd := range disks {
+		quota, err := gd.GetStorageQuota(ctx)
+		if err != nil {
+			slog.Warn("не удалось получить квоту диска", "idDisk", gd.cfg.Id, "error", err)
+			spaces = append(spaces, diskSpace{gd: gd})
+			continue
+		}
+		var stored int64
+		if fileSize > 0 {
+			stored = gd.storedSize(fileSize)
+		}
+		spaces = append(spaces, diskSpace{gd: gd, free: quota.FreeBytes, ok: true, fits: quota.FreeBytes >= stored})
+	}
+
+	// Диски, квоту которых получить не удалось, пробуем последними
+	sort.SliceStable(spaces, func(i, j int) bool {
+		a, b := spaces[i], spaces[j]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		if policy == SelectMostFree {
+			return a.free > b.free
+		}
+		// first-fit: сначала диски, где хватает места, в порядке конфигурации
+		return a.fits && !b.fits
+	})
+
+	result := make([]*GoogleDisk, 0, len(spaces))
+	for _, space := range spaces {
+		result = append(result, space.gd)
+	}
+	return result, nil
+}
@@ -0,0 +1,202 @@
+package googleupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// UploadReader загружает данные r неизвестного размера (например stdout pg_dump) в файл name.
+// Данные отправляются частями по мере чтения, без временного файла.
+// Ротация копий выполняется по name (или UseRemoteName), свободное место проверяется по UseSizeHint.
+// При выборе диска по политике следующий диск пробуется, только пока из r ничего не прочитано
+// example googleupload.UploadReader(ctx, os.Stdin, "db.sql", UseIDDisk("1"), UseSizeHint(1<<30))
+func (gds *GoogleDisks) UploadReader(ctx context.Context, r io.Reader, name string, opts ...UploadOption) (*UploadResult, error) {
+	if name == "" {
+		return nil, errors.New("не задано имя файла для загрузки из потока")
+	}
+
+	o := newUploadOptions(opts)
+	if policy, ok := gds.selectPolicyFor(o.idDisk); ok {
+		return gds.uploadReaderWithFailover(ctx, r, name, policy, o)
+	}
+
+	gd, err := gds.findGDById(o.idDisk)
+	if err != nil {
+		return nil, err
+	}
+	return gd.uploadReader(ctx, r, name, o)
+}
+
+// uploadReaderWithFailover загружает поток на диск, выбранный по политике.
+// Переход на следующий диск возможен, пока данные потока не начали читаться
+func (gds *GoogleDisks) uploadReaderWithFailover(ctx context.Context, r io.Reader, name string, policy SelectPolicy, o *uploadOptions) (*UploadResult, error) {
+	candidates, err := gds.selectDisks(ctx, o.sizeHint, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &countingReader{reader: r}
+	var errs []error
+	for _, gd := range candidates {
+		result, err := gd.uploadReader(ctx, cr, name, o)
+		if err == nil {
+			slog.Info("файл загружен на диск, выбранный по политике", "file", name, "idDisk", gd.cfg.Id, "policy", policy)
+			return result, nil
+		}
+		if ctx.Err() != nil || cr.read.Load() > 0 {
+			return nil, err
+		}
+
+		slog.Warn("не удалось загрузить файл, пробуем следующий диск", "file", name, "idDisk", gd.cfg.Id, "policy", policy, "error", err)
+		errs = append(errs, fmt.Errorf("disk %s: %w", gd.cfg.Id, err))
+	}
+
+	return nil, fmt.Errorf("не удалось загрузить файл ни на один диск: %w", errors.Join(errs...))
+}
+
+// uploadReader загружает поток на диск и проверяет его контрольные суммы
+func (gd *GoogleDisk) uploadReader(ctx context.Context, r io.Reader, name string, o *uploadOptions) (_ *UploadResult, err error) {
+	l := slog.With("file", o.name(name), "idDisk", gd.cfg.Id)
+	started := time.Now()
+
+	copiesCount, err := o.copies(gd)
+	if err != nil {
+		return nil, err
+	}
+
+	// При шифровании отправляются, считаются и проверяются зашифрованные данные.
+	// Размер сжатых данных заранее неизвестен, прогресс при сжатии считается по исходным
+	compressed := gd.cfg.Compression.Codec != ""
+	sizeHint := o.sizeHint
+	if sizeHint > 0 {
+		sizeHint = gd.storedSize(sizeHint)
+	}
+	source := &progressReader{reader: r, fileSize: o.sizeHint}
+	trackTotal := sizeHint
+	if compressed {
+		source.onProgress = o.progress
+		trackTotal = o.sizeHint
+	}
+	tracker := newProgressTracker(gd.cfg.Id, o.name(name), trackTotal, o.progressReporters())
+	defer func() { tracker.finish(err) }()
+
+	// Без подсказки размера проверять свободное место не с чем. Папки создаются после проверки
+	prepare := *o
+	if sizeHint <= 0 {
+		prepare.skipQuotaCheck = true
+	}
+	trashFreed, err := gd.prepareUpload(ctx, sizeHint, &prepare, tracker)
+	if err != nil {
+		return nil, err
+	}
+
+	folderID, family, err := gd.uploadTarget(ctx, o, name)
+	if err != nil {
+		return nil, err
+	}
+	name = family.name
+
+	// Поток начинает читаться только после проверки места: при её ошибке
+	// данные не потеряны и загрузку можно продолжить на другом диске
+	encoded, err := gd.encodeReader(source)
+	if err != nil {
+		return nil, err
+	}
+	defer deferClose("ошибка остановки сжатия", encoded.Close)
+
+	pr := &progressReader{
+		reader:   gd.throttle(ctx, encoded),
+		fileSize: sizeHint,
+		hashes:   newChecksummer(gd.cfg.VerifySHA256),
+	}
+	tracked := pr
+	if compressed {
+		tracked = source
+	} else {
+		pr.onProgress = o.progress
+	}
+
+	driveFile := o.driveFile(folderID, name)
+	driveFile.AppProperties = gd.storedProperties(driveFile.AppProperties, -1)
+
+	tracker.startUpload(tracked)
+	if gd.client != nil {
+		// Части отправляются по мере чтения, неподтверждённая часть повторяется при временных ошибках
+		uploader := &ResumableUploader{
+			Client:       gd.client,
+			ChunkSize:    o.chunkSizeFor(gd),
+			RateLimiters: []*RateLimiter{gd.rateLimit, gd.globalRateLimit},
+		}
+		driveFile, err = uploader.UploadStream(ctx, driveFile, encoded, pr, gd.retry)
+	} else {
+		// Прочитанные данные повторно не отправить, поэтому без повторов
+		var mediaOpts []googleapi.MediaOption
+		if chunkSize := o.chunkSizeFor(gd); chunkSize > 0 {
+			mediaOpts = append(mediaOpts, googleapi.ChunkSize(int(chunkSize)))
+		}
+		driveFile, err = gd.backend.CreateFile(ctx, driveFile, pr, mediaOpts...)
+	}
+	tracker.stopUpload()
+	if err != nil {
+		return nil, fmt.Errorf("error upload file: %w", err)
+	}
+
+	tracker.phase(PhaseVerifying)
+	sums := pr.hashes.Sum()
+	if err := gd.verifyUpload(ctx, driveFile, sums); err != nil {
+		return nil, err
+	}
+
+	tracker.phase(PhaseCleanup)
+	deleted := gd.rotateCopies(ctx, folderID, family, copiesCount, driveFile.Id)
+
+	// Исходный размер сжатого потока известен только после загрузки
+	if compressed {
+		props := map[string]string{PropOriginalSize: strconv.FormatInt(source.Progress(), 10)}
+		if err := gd.backend.SetAppProperties(ctx, driveFile.Id, props); err != nil {
+			l.Warn("ошибка сохранения исходного размера файла", "fileId", driveFile.Id, "error", err)
+		}
+	}
+
+	result := &UploadResult{
+		DiskID:        gd.cfg.Id,
+		FileID:        driveFile.Id,
+		Name:          driveFile.Name,
+		WebViewLink:   driveFile.WebViewLink,
+		Bytes:         pr.hashes.written,
+		Checksums:     sums,
+		Duration:      time.Since(started),
+		DeletedCopies: deleted,
+		TrashFreed:    trashFreed,
+	}
+
+	l.Info("Success upload stream",
+		"fileSize", FormatBytes(result.Bytes),
+		"fileId", result.FileID,
+		"md5", sums.MD5,
+		"duration", result.Duration,
+		slog.String("url", gd.GetUrlFile()),
+	)
+
+	return result, nil
+}
+
+// countingReader считает прочитанные байты
+type countingReader struct {
+	reader io.Reader
+	read   atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,51 @@
+package googleupload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestUploadReaderCompressedFailover(t *testing.T) {
+	ctx := context.Background()
+	data := make([]byte, 256<<10)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// На диске a не хватает места: проверка квоты не должна начать читать поток
+	memA := NewMemoryBackend(1 << 10)
+	memB := NewMemoryBackend(1 << 30)
+	newDisk := func(id string, mem *MemoryBackend) *GoogleDisk {
+		return NewGoogleDisk(&ConfigGoogleDrive{Id: id, Enable: true, UploadCopiesCount: 1, Compression: ConfigCompression{Codec: CodecGzip}}, mem)
+	}
+	gds, err := NewGoogleDisks(newDisk("a", memA), newDisk("b", memB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gds.SelectPolicy = SelectRoundRobin
+
+	result, err := gds.UploadReader(ctx, bytes.NewReader(data), "db.sql", UseSizeHint(int64(len(data))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DiskID != "b" || len(memA.Files()) != 0 {
+		t.Fatalf("загружено на диск %s, файлов на диске a: %d", result.DiskID, len(memA.Files()))
+	}
+
+	stored, err := memB.Content(result.FileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("на диске b загружено %d байт из %d: %v", len(got), len(data), err)
+	}
+}
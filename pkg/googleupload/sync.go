@@ -0,0 +1,329 @@
+package googleupload
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// SyncAction действие синхронизации над файлом
+type SyncAction string
+
+const (
+	SyncUpload SyncAction = "upload" // Файла нет на диске
+	SyncUpdate SyncAction = "update" // Файл изменился, содержимое заменяется с сохранением ID
+	SyncTrash  SyncAction = "trash"  // Файла нет в локальном каталоге или это старый дубликат, он перемещается в корзину
+)
+
+// SyncOptions параметры односторонней синхронизации каталога с папкой диска
+type SyncOptions struct {
+	// Include, Exclude - шаблоны файлов, как в DirUploadOptions.
+	// Исключённые файлы на диске не сравниваются и не удаляются
+	Include []string
+	Exclude []string
+	// DeleteRemote - перемещать в корзину файлы диска, которых нет в локальном каталоге,
+	// и старые дубликаты файлов, которые есть
+	DeleteRemote bool
+	// DryRun - только построить план, ничего не меняя на диске
+	DryRun bool
+	// Parallelism - количество одновременно выполняемых действий, по умолчанию 4
+	Parallelism int
+}
+
+// SyncItem действие над одним файлом, Path - путь относительно каталога
+type SyncItem struct {
+	Action SyncAction `json:"action"`
+	Path   string     `json:"path"`
+	FileID string     `json:"fileId,omitempty"` // ID файла на диске для update и trash
+	Size   int64      `json:"size"`
+
+	local string // путь к локальному файлу для upload и update
+}
+
+// SyncSummary итог синхронизации: план и результат его выполнения
+type SyncSummary struct {
+	Plan      []SyncItem         `json:"plan"`
+	Unchanged int                `json:"unchanged"`
+	Applied   []string           `json:"applied"`
+	Failures  []DirUploadFailure `json:"failures"`
+	Bytes     int64              `json:"bytes"`
+}
+
+// SyncDir синхронизирует локальный каталог dir с папкой FolderID диска idDisk в одну сторону:
+// новые файлы загружаются, изменившиеся (по размеру и md5Checksum) обновляются на месте,
+// чтобы ссылки на них не менялись. Из нескольких файлов диска с одним именем сравнивается самый новый,
+// остальные перемещаются в корзину. При DryRun возвращается только план
+func (gds *GoogleDisks) SyncDir(ctx context.Context, dir string, idDisk string, opts SyncOptions) (*SyncSummary, error) {
+	gd, err := gds.findGDById(idDisk)
+	if err != nil {
+		return nil, err
+	}
+	if gd.encryptor != nil || gd.cfg.Compression.Codec != "" {
+		return nil, fmt.Errorf("disk %s: синхронизация недоступна при шифровании и сжатии, файлы в Drive нельзя сравнить с локальными", gd.cfg.Id)
+	}
+	l := slog.With("dir", dir, "idDisk", gd.cfg.Id)
+
+	plan, err := gd.planSync(ctx, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	summary := &SyncSummary{Plan: plan.items, Unchanged: plan.unchanged}
+	if opts.DryRun || len(plan.items) == 0 {
+		l.Info("Sync plan", "actions", len(plan.items), "unchanged", plan.unchanged, "dryRun", opts.DryRun)
+		return summary, nil
+	}
+
+	var uploadSize int64
+	for _, item := range plan.items {
+		if item.Action != SyncTrash {
+			uploadSize += item.Size
+		}
+	}
+	if _, err := gd.smartClearTrash(ctx, uploadSize); err != nil {
+		return nil, err
+	}
+
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = dirParallelismDefault
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, parallelism)
+	)
+	fail := func(item SyncItem, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		l.Warn("ошибка синхронизации файла", "file", item.Path, "action", item.Action, "error", err)
+		summary.Failures = append(summary.Failures, newDirUploadFailure(item.Path, err))
+	}
+
+	for _, item := range plan.items {
+		var folderID string
+		if item.Action == SyncUpload {
+			// Папки создаются последовательно, чтобы не появились дубликаты
+			folderID, _, err = plan.folders.ensure(ctx, path.Dir(item.Path))
+			if err != nil {
+				fail(item, err)
+				continue
+			}
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return summary, ctx.Err()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := gd.applySyncItem(ctx, folderID, item); err != nil {
+				fail(item, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			summary.Applied = append(summary.Applied, item.Path)
+			if item.Action != SyncTrash {
+				summary.Bytes += item.Size
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(summary.Applied)
+	l.Info("Success sync dir",
+		"applied", len(summary.Applied),
+		"unchanged", summary.Unchanged,
+		"failures", len(summary.Failures),
+		"size", FormatBytes(summary.Bytes),
+	)
+	return summary, nil
+}
+
+// applySyncItem выполняет одно действие плана
+func (gd *GoogleDisk) applySyncItem(ctx context.Context, folderID string, item SyncItem) error {
+	if item.Action == SyncTrash {
+		if err := gd.backend.TrashFile(ctx, item.FileID); err != nil {
+			return fmt.Errorf("ошибка перемещения в корзину: %w", err)
+		}
+		return nil
+	}
+
+	file, err := os.Open(item.local)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия файла: %w", err)
+	}
+	defer deferClose("ошибка закрытия файла", file.Close)
+
+	err = gd.retry.Do(ctx, string(item.Action), func() error {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if item.Action == SyncUpdate {
+			_, err = gd.backend.UpdateFile(ctx, item.FileID, gd.throttle(ctx, file))
+		} else {
+			_, err = gd.backend.CreateFile(ctx, newDriveFile(folderID, path.Base(item.Path)), gd.throttle(ctx, file))
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error upload file: %w", err)
+	}
+	return nil
+}
+
+// syncPlan план синхронизации и найденные на диске папки
+type syncPlan struct {
+	items     []SyncItem
+	unchanged int
+	folders   *folderCache
+}
+
+// planSync сравнивает локальный каталог с папкой диска и строит план действий
+func (gd *GoogleDisk) planSync(ctx context.Context, dir string, opts SyncOptions) (*syncPlan, error) {
+	local, err := collectDirFiles(dir, DirUploadOptions{Include: opts.Include, Exclude: opts.Exclude})
+	if err != nil {
+		return nil, err
+	}
+
+	folders := &folderCache{gd: gd, ids: map[string]string{".": gd.cfg.FolderID}}
+	remote := make(map[string][]*drive.File)
+	if err := gd.listRemoteTree(ctx, gd.cfg.FolderID, ".", folders.ids, remote); err != nil {
+		return nil, fmt.Errorf("ошибка получения списка файлов диска: %w", err)
+	}
+
+	plan := &syncPlan{folders: folders}
+	plan.items, plan.unchanged, err = diffSync(local, remote, opts, fileMD5)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// listRemoteTree рекурсивно собирает файлы папки folderID по относительным путям.
+// Файлы с одним именем собираются от старых к новым, как их возвращает ListFolder
+func (gd *GoogleDisk) listRemoteTree(ctx context.Context, folderID, rel string, folders map[string]string, files map[string][]*drive.File) error {
+	list, err := gd.backend.ListFolder(ctx, folderID)
+	if err != nil {
+		return err
+	}
+	for _, f := range list {
+		p := path.Join(rel, f.Name)
+		switch {
+		case f.MimeType == FolderMimeType:
+			if _, ok := folders[p]; ok {
+				continue
+			}
+			folders[p] = f.Id
+			if err := gd.listRemoteTree(ctx, f.Id, p, folders, files); err != nil {
+				return err
+			}
+		case strings.HasPrefix(f.MimeType, "application/vnd.google-apps."):
+			// Документы Google не имеют md5Checksum и не синхронизируются
+		default:
+			files[p] = append(files[p], f)
+		}
+	}
+	return nil
+}
+
+// diffSync сравнивает локальные файлы с самыми новыми из файлов диска с тем же путём (последними в remote).
+// Более старые дубликаты перемещаются в корзину только с DeleteRemote: без него это могут быть копии,
+// которые хранит ротация UploadCopiesCount. Контрольная сумма локального файла
+// считается через hash только при совпадении размеров
+func diffSync(local []dirEntry, remote map[string][]*drive.File, opts SyncOptions, hash func(string) (string, error)) ([]SyncItem, int, error) {
+	var (
+		items     []SyncItem
+		unchanged int
+	)
+	seen := make(map[string]bool, len(local))
+	for _, f := range local {
+		seen[f.rel] = true
+		copies := remote[f.rel]
+		if len(copies) == 0 {
+			items = append(items, SyncItem{Action: SyncUpload, Path: f.rel, Size: f.size, local: f.path})
+			continue
+		}
+		r := copies[len(copies)-1]
+		if opts.DeleteRemote {
+			items = append(items, trashItems(f.rel, copies[:len(copies)-1])...)
+		}
+
+		changed := r.Size != f.size
+		if !changed && r.Md5Checksum != "" {
+			sum, err := hash(f.path)
+			if err != nil {
+				return nil, 0, err
+			}
+			changed = !strings.EqualFold(sum, r.Md5Checksum)
+		}
+		if !changed {
+			unchanged++
+			continue
+		}
+		items = append(items, SyncItem{Action: SyncUpdate, Path: f.rel, FileID: r.Id, Size: f.size, local: f.path})
+	}
+
+	if opts.DeleteRemote {
+		for rel, copies := range remote {
+			if seen[rel] || excludedPath(opts.Exclude, rel) || (len(opts.Include) > 0 && !matchAny(opts.Include, rel)) {
+				continue
+			}
+			items = append(items, trashItems(rel, copies)...)
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	return items, unchanged, nil
+}
+
+// trashItems возвращает действия перемещения в корзину файлов диска с путём rel
+func trashItems(rel string, files []*drive.File) []SyncItem {
+	items := make([]SyncItem, 0, len(files))
+	for _, f := range files {
+		items = append(items, SyncItem{Action: SyncTrash, Path: rel, FileID: f.Id, Size: f.Size})
+	}
+	return items
+}
+
+// excludedPath проверяет по шаблонам исключения сам путь rel и все его родительские папки
+func excludedPath(patterns []string, rel string) bool {
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if matchAny(patterns, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileMD5 возвращает md5 файла в шестнадцатеричном виде, как md5Checksum в Drive
+func fileMD5(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("ошибка открытия файла: %w", err)
+	}
+	defer deferClose("ошибка закрытия файла", file.Close)
+
+	h := md5.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,162 @@
+package googleupload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree создаёт в каталоге dir файлы с путями через "/" и содержимым
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// planActions возвращает действия плана в виде "action path"
+func planActions(items []SyncItem) []string {
+	actions := make([]string, 0, len(items))
+	for _, item := range items {
+		actions = append(actions, string(item.Action)+" "+item.Path)
+	}
+	return actions
+}
+
+// newSyncDisk возвращает диск с папкой синхронизации "sync" в MemoryBackend
+func newSyncDisk(t *testing.T) (*GoogleDisks, *MemoryBackend, string) {
+	t.Helper()
+	mem := NewMemoryBackend(1 << 20)
+	folder, err := mem.CreateFolder(context.Background(), "", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gds, err := NewGoogleDisks(NewGoogleDisk(&ConfigGoogleDrive{Id: "d", FolderID: folder.Id, Enable: true}, mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gds, mem, folder.Id
+}
+
+func TestSyncDir(t *testing.T) {
+	ctx := context.Background()
+	gds, mem, folderID := newSyncDisk(t)
+
+	// На диске: неизменный, изменённый того же размера, изменённый по размеру, удалённый локально,
+	// два дубликата (с DeleteRemote старый перемещается в корзину) и исключённый файл
+	same := mem.AddFile(folderID, "same.txt", []byte("same"))
+	changed := mem.AddFile(folderID, "changed.txt", []byte("old!"))
+	grown := mem.AddFile(folderID, "grown.txt", []byte("old"))
+	mem.AddFile(folderID, "deleted.txt", []byte("deleted"))
+	oldDup := mem.AddFile(folderID, "dup.txt", []byte("dup"))
+	newDup := mem.AddFile(folderID, "dup.txt", []byte("dup"))
+	mem.AddFile(folderID, "skip.log", []byte("log"))
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"same.txt":       "same",
+		"changed.txt":    "new!",
+		"grown.txt":      "grown",
+		"dup.txt":        "dup",
+		"new.txt":        "new",
+		"sub/nested.txt": "nested",
+	})
+	opts := SyncOptions{DeleteRemote: true, Exclude: []string{"*.log"}}
+
+	// Пробный запуск только строит план
+	opts.DryRun = true
+	plan, err := gds.SyncDir(ctx, dir, "d", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"update changed.txt",
+		"trash deleted.txt",
+		"trash dup.txt",
+		"update grown.txt",
+		"upload new.txt",
+		"upload sub/nested.txt",
+	}
+	if got := planActions(plan.Plan); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("план %v, ожидался %v", got, want)
+	}
+	if plan.Unchanged != 2 || len(plan.Applied) != 0 || len(mem.Files()) != 8 {
+		t.Fatalf("пробный запуск: unchanged %d, applied %v, файлов %d", plan.Unchanged, plan.Applied, len(mem.Files()))
+	}
+	for _, item := range plan.Plan {
+		if item.Action == SyncTrash && item.Path == "dup.txt" && item.FileID != oldDup.Id {
+			t.Errorf("в корзину перемещается новый дубликат %s вместо старого %s", item.FileID, oldDup.Id)
+		}
+	}
+
+	opts.DryRun = false
+	summary, err := gds.SyncDir(ctx, dir, "d", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Failures) != 0 || len(summary.Applied) != len(want) {
+		t.Fatalf("применено %v, ошибки %v", summary.Applied, summary.Failures)
+	}
+
+	// Изменённые файлы обновлены на месте, ID и ссылки сохранены
+	for id, data := range map[string]string{same.Id: "same", changed.Id: "new!", grown.Id: "grown", newDup.Id: "dup"} {
+		if got, err := mem.Content(id); err != nil || string(got) != data {
+			t.Errorf("файл %s: %q, %v, ожидалось %q", id, got, err, data)
+		}
+	}
+	want = []string{
+		"changed.txt", "dup.txt", "grown.txt", "nested.txt", "new.txt", "same.txt", "skip.log", "sub",
+		"sync", "trash:deleted.txt", "trash:dup.txt",
+	}
+	if got := fileNames(mem); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("файлы диска %v, ожидалось %v", got, want)
+	}
+
+	// Повторная синхронизация ничего не меняет
+	again, err := gds.SyncDir(ctx, dir, "d", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Plan) != 0 || again.Unchanged != 6 {
+		t.Errorf("повторная синхронизация: план %v, unchanged %d", planActions(again.Plan), again.Unchanged)
+	}
+}
+
+func TestSyncDirKeepsRemoteWithoutDelete(t *testing.T) {
+	ctx := context.Background()
+	gds, mem, folderID := newSyncDisk(t)
+	mem.AddFile(folderID, "remote-only.txt", []byte("remote"))
+	mem.AddFile(folderID, "remote-only.txt", []byte("remote"))
+	// Старые копии db.sql, например оставленные ротацией UploadCopiesCount
+	oldCopy := mem.AddFile(folderID, "db.sql", []byte("v1"))
+	newCopy := mem.AddFile(folderID, "db.sql", []byte("v2"))
+
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"local.txt": "local", "db.sql": "v3"})
+
+	// Без DeleteRemote файлы, которых нет локально, и дубликаты локальных файлов не трогаются
+	summary, err := gds.SyncDir(ctx, dir, "d", SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := planActions(summary.Plan); strings.Join(got, ",") != "update db.sql,upload local.txt" {
+		t.Errorf("план %v", got)
+	}
+	if got := fileNames(mem); strings.Join(got, ",") != "db.sql,db.sql,local.txt,remote-only.txt,remote-only.txt,sync" {
+		t.Errorf("файлы диска %v", got)
+	}
+	// Обновляется самая новая копия, старая остаётся как есть
+	for id, data := range map[string]string{oldCopy.Id: "v1", newCopy.Id: "v3"} {
+		if got, err := mem.Content(id); err != nil || string(got) != data {
+			t.Errorf("файл %s: %q, %v, ожидалось %q", id, got, err, data)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package googleupload
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// tarBlockSize размер блока tar: заголовки и данные файлов выравниваются по нему
+const tarBlockSize = 512
+
+// TarOptions параметры загрузки каталога одним архивом tar
+type TarOptions struct {
+	// Exclude - шаблоны исключаемых файлов и каталогов, как в DirUploadOptions
+	Exclude []string
+}
+
+// tarEntry файл, каталог или символическая ссылка для записи в архив
+type tarEntry struct {
+	path string      // Путь в локальной файловой системе
+	name string      // Путь в архиве
+	info fs.FileInfo // Без перехода по символическим ссылкам
+}
+
+// UploadDirTar загружает каталог dir одним архивом tar с именем "<каталог>.tar" (или UseRemoteName).
+// Архив пишется потоком прямо в загрузку, без временного файла; права, время изменения
+// и символические ссылки сохраняются, пути в архиве начинаются с имени каталога.
+// Сжатие и шифрование архива задаются конфигурацией диска (compression, encryption),
+// ротация копий - по имени архива, как в UploadFile
+// example googleupload.UploadDirTar(ctx, "/var/www", TarOptions{Exclude: []string{"*.log", "cache"}}, UseIDDisk("1"))
+func (gds *GoogleDisks) UploadDirTar(ctx context.Context, dir string, opts TarOptions, uploadOpts ...UploadOption) (*UploadResult, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения пути каталога %s: %w", dir, err)
+	}
+	base := filepath.Base(absDir)
+
+	entries, size, err := collectTarEntries(absDir, base, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("архивация каталога", "dir", dir, "entries", len(entries), "size", FormatBytes(size))
+
+	pipeReader, pipeWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = pipeWriter.CloseWithError(writeTar(pipeWriter, entries))
+	}()
+	defer func() {
+		// Закрытие pipe останавливает архивацию, если загрузка завершилась с ошибкой
+		_ = pipeReader.Close()
+		<-done
+	}()
+
+	// Размер архива по умолчанию - подсказка для проверки места и прогресса, UseSizeHint её заменяет
+	streamOpts := append([]UploadOption{UseSizeHint(size)}, uploadOpts...)
+	return gds.UploadReader(ctx, pipeReader, base+".tar", streamOpts...)
+}
+
+// collectTarEntries обходит каталог и возвращает записи архива и ожидаемый размер архива.
+// Сокеты в tar не записываются и пропускаются
+func collectTarEntries(dir, base string, exclude []string) ([]tarEntry, int64, error) {
+	var (
+		entries []tarEntry
+		size    int64 = 2 * tarBlockSize // Два нулевых блока в конце архива
+	)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && matchAny(exclude, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSocket != 0 {
+			slog.Warn("сокет пропущен при архивации", "file", p)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, tarEntry{path: p, name: path.Join(base, rel), info: info})
+		size += tarBlockSize
+		if info.Mode().IsRegular() {
+			size += (info.Size() + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка обхода каталога %s: %w", dir, err)
+	}
+	return entries, size, nil
+}
+
+// writeTar пишет записи в архив tar
+func writeTar(w io.Writer, entries []tarEntry) error {
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		if err := writeTarEntry(tw, e); err != nil {
+			return fmt.Errorf("ошибка записи %s в архив: %w", e.path, err)
+		}
+	}
+	return tw.Close()
+}
+
+// writeTarEntry пишет в архив заголовок записи и содержимое файла
+func writeTarEntry(tw *tar.Writer, e tarEntry) error {
+	var link string
+	if e.info.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(e.path); err != nil {
+			return err
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(e.info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = e.name
+	if e.info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !e.info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(e.path)
+	if err != nil {
+		return err
+	}
+	defer deferClose("ошибка закрытия файла", file.Close)
+
+	// Записывается размер из заголовка: данные, дописанные в файл во время архивации, не испортят архив
+	n, err := io.CopyN(tw, file, hdr.Size)
+	if errors.Is(err, io.EOF) {
+		// Файл уменьшился во время архивации (например, обрезанный лог): как GNU tar, дополняем нулями
+		// до размера из заголовка, чтобы не прерывать архивацию остальных файлов
+		slog.Warn("файл уменьшился во время архивации, дополнен нулями", "file", e.path, "size", hdr.Size, "read", n)
+		_, err = io.CopyN(tw, zeroReader{}, hdr.Size-n)
+	}
+	return err
+}
+
+// zeroReader бесконечный источник нулевых байт
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
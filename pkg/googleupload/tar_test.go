@@ -0,0 +1,57 @@
+package googleupload
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteTarPadsShrunkFile(t *testing.T) {
+	dir := t.TempDir()
+	logData := []byte(strings.Repeat("log line\n", 100))
+	if err := os.WriteFile(filepath.Join(dir, "app.log"), logData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	entries, _, err := collectTarEntries(dir, "site", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Лог обрезан после обхода каталога, до записи в архив
+	if err := os.Truncate(filepath.Join(dir, "app.log"), 100); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := writeTar(&archive, entries); err != nil {
+		t.Fatalf("архивация прервана: %v", err)
+	}
+
+	got := map[string][]byte{}
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[hdr.Name], err = io.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := append(append([]byte(nil), logData[:100]...), make([]byte, len(logData)-100)...)
+	if !bytes.Equal(got["site/app.log"], want) {
+		t.Errorf("app.log в архиве: %d байт, ожидалось %d байт с нулями после обрезки", len(got["site/app.log"]), len(want))
+	}
+	if string(got["site/data.txt"]) != "data" {
+		t.Errorf("файл после обрезанного не записан: %q", got["site/data.txt"])
+	}
+}
@@ -0,0 +1,219 @@
+package googleupload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleReadSize - наибольший размер одного чтения при ограничении скорости, чтобы данные шли равномерно
+const throttleReadSize = 32 * 1024
+
+// RateWindow ограничение скорости в интервале времени суток [From, To) по местному времени.
+// Интервал может переходить через полночь, например From: "22:00", To: "06:00"
+type RateWindow struct {
+	From  string `yaml:"from" mapstructure:"from"`   // Начало интервала, ЧЧ:ММ
+	To    string `yaml:"to" mapstructure:"to"`       // Конец интервала, ЧЧ:ММ
+	Limit string `yaml:"limit" mapstructure:"limit"` // Скорость, например 2MB (в секунду), 0 - без ограничения
+}
+
+// rateWindow разобранный RateWindow, время - минуты от начала суток
+type rateWindow struct {
+	from, to int
+	limit    int64
+}
+
+func (w rateWindow) contains(minute int) bool {
+	if w.from <= w.to {
+		return minute >= w.from && minute < w.to
+	}
+	return minute >= w.from || minute < w.to
+}
+
+// RateLimiter ограничивает скорость отправки данных в байтах в секунду алгоритмом token bucket.
+// Один RateLimiter может использоваться несколькими загрузками одновременно, тогда скорость делится между ними
+type RateLimiter struct {
+	// Now возвращает текущее время, по умолчанию time.Now
+	Now func() time.Time
+	// Sleep ждёт d или отмены ctx, по умолчанию таймер. Вместе с Now позволяет подменить часы в тестах
+	Sleep func(ctx context.Context, d time.Duration) error
+
+	mu      sync.Mutex
+	base    int64
+	windows []rateWindow
+	tokens  float64
+	last    time.Time
+}
+
+// NewRateLimiter создаёт ограничение bytesPerSecond байт в секунду, 0 - без ограничения
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{Now: time.Now, Sleep: sleepContext, base: bytesPerSecond}
+}
+
+// NewScheduledRateLimiter создаёт ограничение limit (например "2MB") с расписанием по времени суток.
+// Вне интервалов расписания действует limit. Если не задано ни limit, ни расписание, возвращает nil
+func NewScheduledRateLimiter(limit string, schedule []RateWindow) (*RateLimiter, error) {
+	base, err := ParseByteSize(limit)
+	if err != nil {
+		return nil, fmt.Errorf("неверное ограничение скорости %q: %w", limit, err)
+	}
+	if base == 0 && len(schedule) == 0 {
+		return nil, nil
+	}
+
+	l := NewRateLimiter(base)
+	for _, w := range schedule {
+		from, err := parseTimeOfDay(w.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseTimeOfDay(w.To)
+		if err != nil {
+			return nil, err
+		}
+		windowLimit, err := ParseByteSize(w.Limit)
+		if err != nil {
+			return nil, fmt.Errorf("неверное ограничение скорости %q: %w", w.Limit, err)
+		}
+		l.windows = append(l.windows, rateWindow{from: from, to: to, limit: windowLimit})
+	}
+	return l, nil
+}
+
+// RateAt возвращает ограничение скорости в момент t, 0 - без ограничения.
+// Действует первый подходящий интервал расписания
+func (l *RateLimiter) RateAt(t time.Time) int64 {
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range l.windows {
+		if w.contains(minute) {
+			return w.limit
+		}
+	}
+	return l.base
+}
+
+// WaitN учитывает отправку n байт и ждёт, пока скорость не вернётся в пределы ограничения
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.Now()
+	rate := float64(l.RateAt(now))
+	if rate <= 0 {
+		// Без ограничения запас не копится, чтобы после смены расписания не было всплеска
+		l.tokens = 0
+		l.last = now
+		l.mu.Unlock()
+		return nil
+	}
+
+	// Запас не больше секунды отправки, начинаем с полного запаса
+	if l.last.IsZero() {
+		l.tokens = rate
+	} else {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*rate, rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	return l.Sleep(ctx, wait)
+}
+
+// throttledReader читает данные не быстрее ограничений limiters
+type throttledReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	limiters []*RateLimiter
+}
+
+// throttleReader оборачивает r ограничениями скорости, nil ограничения пропускаются
+func throttleReader(ctx context.Context, r io.Reader, limiters ...*RateLimiter) io.Reader {
+	active := make([]*RateLimiter, 0, len(limiters))
+	for _, l := range limiters {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+	if len(active) == 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, reader: r, limiters: active}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleReadSize {
+		p = p[:throttleReadSize]
+	}
+	n, err := t.reader.Read(p)
+	for _, l := range t.limiters {
+		if waitErr := l.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// ParseByteSize разбирает размер вида 1048576, 512KB, 2MB, 1.5GB (двоичные единицы, суффикс "/s" допускается).
+// Пустая строка, "0" и "unlimited" - 0
+func ParseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "/S")
+	if s == "" || s == "UNLIMITED" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("неверный размер: %s", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// parseTimeOfDay разбирает время ЧЧ:ММ в минуты от начала суток
+func parseTimeOfDay(s string) (int, error) {
+	if strings.TrimSpace(s) == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("неверное время %q, ожидается ЧЧ:ММ", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// sleepContext ждёт d или отмены ctx
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
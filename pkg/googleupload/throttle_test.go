@@ -0,0 +1,236 @@
+package googleupload
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock часы RateLimiter для тестов: Sleep не ждёт, а переводит время вперёд и запоминает паузы
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(_ context.Context, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.sleeps = append(c.sleeps, d)
+	return nil
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Slept возвращает суммарное время пауз и сбрасывает их
+func (c *fakeClock) Slept() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var total time.Duration
+	for _, d := range c.sleeps {
+		total += d
+	}
+	c.sleeps = nil
+	return total
+}
+
+func (c *fakeClock) attach(l *RateLimiter) *RateLimiter {
+	l.Now = c.Now
+	l.Sleep = c.Sleep
+	return l
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local))
+	l := clock.attach(NewRateLimiter(1000))
+
+	steps := []struct {
+		advance time.Duration
+		n       int
+		sleep   time.Duration
+	}{
+		{n: 1000},                               // Начальный запас - секунда отправки
+		{n: 500, sleep: 500 * time.Millisecond}, // Запас исчерпан, ждём
+		{advance: 250 * time.Millisecond, n: 250},
+		{advance: 10 * time.Second, n: 1500, sleep: 500 * time.Millisecond}, // Запас не копится больше секунды
+		{n: 0},
+	}
+	for i, s := range steps {
+		clock.Advance(s.advance)
+		if err := l.WaitN(ctx, s.n); err != nil {
+			t.Fatal(err)
+		}
+		if got := clock.Slept(); got != s.sleep {
+			t.Errorf("шаг %d: пауза %s, ожидалось %s", i, got, s.sleep)
+		}
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	l := NewRateLimiter(1)
+	if err := l.WaitN(ctx, 1); err != nil {
+		t.Fatalf("запас начального окна: %v", err)
+	}
+	// Настоящий Sleep возвращает ошибку отменённого контекста вместо ожидания
+	if err := l.WaitN(ctx, 100); err != context.Canceled {
+		t.Errorf("ожидалась отмена, получено %v", err)
+	}
+}
+
+func TestRateLimiterSchedule(t *testing.T) {
+	l, err := NewScheduledRateLimiter("2MB", []RateWindow{
+		{From: "22:00", To: "06:00", Limit: "1MB"}, // Через полночь
+		{From: "09:00", To: "18:00", Limit: "0"},
+		{From: "17:00", To: "24:00", Limit: "512KB"}, // Перекрытие: действует первый подходящий интервал
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		t    time.Time
+		want int64
+	}{
+		{at(22, 0), 1 << 20},
+		{at(23, 59), 1 << 20},
+		{at(0, 0), 1 << 20},
+		{at(5, 59), 1 << 20},
+		{at(6, 0), 2 << 20},
+		{at(8, 59), 2 << 20},
+		{at(9, 0), 0},
+		{at(17, 30), 0},
+		{at(18, 0), 512 << 10},
+		{at(21, 59), 512 << 10},
+	}
+	for _, tt := range tests {
+		if got := l.RateAt(tt.t); got != tt.want {
+			t.Errorf("RateAt(%s) = %d, ожидалось %d", tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterScheduleSwitch(t *testing.T) {
+	ctx := context.Background()
+	l, err := NewScheduledRateLimiter("", []RateWindow{{From: "22:00", To: "06:00", Limit: "1KB"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock(time.Date(2024, 1, 1, 21, 59, 0, 0, time.Local))
+	clock.attach(l)
+
+	// До начала интервала ограничения нет
+	if err := l.WaitN(ctx, 1<<30); err != nil || clock.Slept() != 0 {
+		t.Fatalf("без ограничения: пауза, %v", err)
+	}
+
+	// Через минуту действует 1KB в секунду: отправка без ограничения не даёт запаса больше секунды
+	clock.Advance(time.Minute)
+	if err := l.WaitN(ctx, 3072); err != nil {
+		t.Fatal(err)
+	}
+	if got := clock.Slept(); got != 2*time.Second {
+		t.Errorf("пауза после начала интервала %s, ожидалось 2s", got)
+	}
+}
+
+func TestNewScheduledRateLimiter(t *testing.T) {
+	if l, err := NewScheduledRateLimiter("", nil); l != nil || err != nil {
+		t.Errorf("без ограничения: %v, %v", l, err)
+	}
+	for _, tt := range []struct {
+		limit    string
+		schedule []RateWindow
+	}{
+		{limit: "fast"},
+		{schedule: []RateWindow{{From: "25:00", To: "06:00"}}},
+		{schedule: []RateWindow{{From: "22:00", To: "6"}}},
+		{schedule: []RateWindow{{From: "22:00", To: "06:00", Limit: "-1MB"}}},
+	} {
+		if _, err := NewScheduledRateLimiter(tt.limit, tt.schedule); err == nil {
+			t.Errorf("ожидалась ошибка для %q %v", tt.limit, tt.schedule)
+		}
+	}
+}
+
+func TestGlobalRateLimitShared(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local))
+	diskA := NewGoogleDisk(&ConfigGoogleDrive{Id: "a"}, NewMemoryBackend(0))
+	diskB := NewGoogleDisk(&ConfigGoogleDrive{Id: "b"}, NewMemoryBackend(0))
+	gds, err := NewGoogleDisks(diskA, diskB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gds.SetGlobalRateLimit(clock.attach(NewRateLimiter(64 << 10)))
+
+	read := func(gd *GoogleDisk, size int) {
+		t.Helper()
+		n, err := io.Copy(io.Discard, gd.throttle(ctx, bytes.NewReader(make([]byte, size))))
+		if err != nil || n != int64(size) {
+			t.Fatalf("прочитано %d, %v", n, err)
+		}
+	}
+
+	// Общий запас в секунду расходуется загрузками на оба диска
+	read(diskA, 64<<10)
+	if got := clock.Slept(); got != 0 {
+		t.Fatalf("пауза в пределах запаса: %s", got)
+	}
+	read(diskB, 128<<10)
+	if got := clock.Slept(); got != 2*time.Second {
+		t.Errorf("пауза диска b %s, ожидалось 2s", got)
+	}
+
+	// Собственное ограничение диска действует вместе с общим
+	diskA.SetRateLimit(clock.attach(NewRateLimiter(32 << 10)))
+	clock.Advance(time.Hour)
+	read(diskA, 64<<10)
+	if got := clock.Slept(); got != time.Second {
+		t.Errorf("пауза диска a с собственным ограничением %s, ожидалось 1s", got)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"", 0}, {"0", 0}, {"unlimited", 0}, {"1048576", 1 << 20}, {"512KB", 512 << 10},
+		{"2MB/s", 2 << 20}, {"1.5G", 3 << 29}, {" 10 mb ", 10 << 20}, {"100B", 100},
+	}
+	for _, tt := range tests {
+		if got, err := ParseByteSize(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v, ожидалось %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"MB", "-1", "ten"} {
+		if _, err := ParseByteSize(in); err == nil {
+			t.Errorf("ParseByteSize(%q): ожидалась ошибка", in)
+		}
+	}
+}
@@ -5,135 +5,59 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"net/http"
-	"net/url"
 	"os/exec"
-	"path/filepath"
 	"runtime"
-	"strings"
-	"time"
 
 	"golang.org/x/oauth2"
 )
 
 // GetToken возвращает токен доступа (из кэша, обновляет или запрашивает новый)
 func (gd *GoogleDisk) GetToken(config *oauth2.Config) (*oauth2.Token, error) {
-	l := slog.With("idDisk", gd.cfg.Id)
-	tokenFile := strings.TrimSuffix(gd.cfg.GoogleCredentialsFile, filepath.Ext(gd.cfg.GoogleCredentialsFile)) + "_token.json"
-	token, err := LoadToken(tokenFile)
-	if err == nil {
-		// Если токен валиден - возвращаем сразу
-		if token.Valid() {
-			return token, nil
-		}
-
-		// Если токен истёк, но есть refresh token - пробуем обновить
-		if token.RefreshToken != "" {
-			l.Info("Токен истёк, пробуем обновить через refresh token")
-			newToken, refreshErr := config.TokenSource(context.Background(), token).Token()
-			if refreshErr == nil {
-				// Сохраняем обновлённый токен
-				saveErr := SaveToken(tokenFile, newToken)
-				if saveErr == nil {
-					l.Info("Token update and save")
-					return newToken, nil
-				}
-				l.Warn("Не удалось сохранить обновлённый токен", "error", saveErr)
-				return newToken, nil
-			}
-			l.Warn("Не удалось обновить токен", "error", refreshErr)
-		}
+	if token, ok := gd.loadToken(config); ok {
+		return token, nil
 	}
 
 	// Если токена нет, он истёк без refresh token или ошибка загрузки - запрашиваем новый
-	code, err := gd.getCodeAuth(config)
-	if err != nil {
-		return nil, err
-	}
+	results := NewAuthManager().Authorize(context.Background(), []AuthRequest{{Disk: gd, Config: config}})
+	return results[0].Token, results[0].Err
+}
 
-	// Обмен кода на токен
-	newToken, err := config.Exchange(context.Background(), code)
+// loadToken загружает токен из хранилища, при необходимости обновляет его через refresh token.
+// false - нужна интерактивная авторизация
+func (gd *GoogleDisk) loadToken(config *oauth2.Config) (*oauth2.Token, bool) {
+	l := slog.With("idDisk", gd.cfg.Id)
+	if gd.tokenStore == nil {
+		return nil, false
+	}
+	token, err := gd.tokenStore.Load()
 	if err != nil {
-		return nil, fmt.Errorf("ошибка обмена кода на токен: %w", err)
+		return nil, false
 	}
 
-	// Сохраняем новый токен
-	if err := SaveToken(tokenFile, newToken); err != nil {
-		slog.Warn("Не удалось сохранить токен", "error", err)
+	// Если токен валиден - возвращаем сразу
+	if token.Valid() {
+		return token, true
 	}
 
-	return newToken, nil
-}
-
-func (gd *GoogleDisk) getCodeAuth(config *oauth2.Config) (string, error) {
-	l := slog.With("IDDisk", gd.cfg.Id)
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-
-	// HTTP сервер для приёма редиректа
-	// Извлекаем хост и порт из config.RedirectURL
-	redirectURL, err := url.Parse(config.RedirectURL)
-	if err != nil {
-		return "", err
+	// Если токен истёк, но есть refresh token - пробуем обновить
+	if token.RefreshToken == "" {
+		return nil, false
 	}
 
-	// Канал для получения кода авторизации
-	codeChan := make(chan string, 1)
-
-	server := &http.Server{Addr: redirectURL.Host}
-	http.HandleFunc("/oauth2/callback", func(w http.ResponseWriter, r *http.Request) {
-		code := r.URL.Query().Get("code")
-		state := r.URL.Query().Get("state")
-
-		if state != "state-token" {
-			http.Error(w, "Неверный state параметр", http.StatusBadRequest)
-			return
-		}
-
-		if code == "" {
-			http.Error(w, "Код не получен", http.StatusBadRequest)
-			return
-		}
-
-		// Отправляем код в канал
-		codeChan <- code
-
-		// Возвращаем страницу успеха
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_, _ = fmt.Fprint(w, `
-			<!DOCTYPE html>
-			<html>
-			<body>
-			Authorization successful
-			</body>
-			</html>
-		`)
-	})
-
-	// Запускаем сервер
-	go func() {
-		l.Info("Ожидание авторизации", "address", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			l.Error("Ошибка HTTP сервера", "error", err)
-		}
-	}()
-
-	// Открываем браузер с ссылкой авторизации
-	l.Info("Открываю браузер для авторизации", "url", authURL)
-	if err := openBrowser(authURL); err != nil {
-		l.Warn("Не удалось открыть браузер, скопируйте ссылку вручную", "url", authURL)
+	l.Info("Токен истёк, пробуем обновить через refresh token")
+	newToken, err := config.TokenSource(context.Background(), token).Token()
+	if err != nil {
+		l.Warn("Не удалось обновить токен", "error", err)
+		return nil, false
 	}
 
-	// Ждём получение кода с таймаутом 5 минут
-	var code string
-	select {
-	case code = <-codeChan:
-		l.Info("Получен код авторизации")
-		_ = server.Shutdown(context.Background())
-	case <-time.After(5 * time.Minute):
-		_ = server.Shutdown(context.Background())
-		return "", fmt.Errorf("время ожидания авторизации истекло (5 минут)")
+	// Сохраняем обновлённый токен
+	if err := gd.tokenStore.Save(newToken); err != nil {
+		l.Warn("Не удалось сохранить обновлённый токен", "error", err)
+		return newToken, true
 	}
-	return code, nil
+	l.Info("Token update and save")
+	return newToken, true
 }
 
 // LoadToken загружает токен из JSON файла
@@ -143,8 +67,8 @@ func LoadToken(fileToken string) (*oauth2.Token, error) {
 		return nil, err
 	}
 
-	token := &oauth2.Token{}
-	if err := json.Unmarshal(data, token); err != nil {
+	token, err := unmarshalToken(data)
+	if err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,63 @@
+package googleupload
+
+import (
+	"log/slog"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// persistingTokenSource сохраняет в хранилище каждый обновлённый токен,
+// чтобы при долгих загрузках токен на диске не устаревал и новый refresh token не терялся.
+// Без хранилища (store nil) токен только обновляется
+type persistingTokenSource struct {
+	mu    sync.Mutex
+	base  oauth2.TokenSource
+	store TokenStore
+	last  *oauth2.Token
+	l     *slog.Logger
+}
+
+// newPersistingTokenSource оборачивает base, token - токен, уже сохранённый в store
+func newPersistingTokenSource(base oauth2.TokenSource, store TokenStore, token *oauth2.Token, l *slog.Logger) oauth2.TokenSource {
+	return &persistingTokenSource{
+		base:  base,
+		store: store,
+		last:  token,
+		l:     l,
+	}
+}
+
+// Token возвращает токен и сохраняет его, если он изменился после обновления
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.last != nil && token.AccessToken == s.last.AccessToken && token.RefreshToken == s.last.RefreshToken {
+		return token, nil
+	}
+
+	last := s.last
+	// Токен запоминается и при ошибке сохранения, иначе каждый вызов Token повторял бы Save
+	s.last = token
+	if s.store == nil {
+		return token, nil
+	}
+	if err := s.store.Save(token); err != nil {
+		// Токен в памяти рабочий, попробуем сохранить при следующем обновлении
+		s.l.Warn("Не удалось сохранить обновлённый токен", "error", err)
+		return token, nil
+	}
+
+	if last != nil && token.RefreshToken != last.RefreshToken {
+		s.l.Info("Refresh token rotated and saved")
+	} else {
+		s.l.Info("Token update and save")
+	}
+	return token, nil
+}
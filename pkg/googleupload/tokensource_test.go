@@ -0,0 +1,188 @@
+package googleupload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// refreshServer - httptest замена token endpoint Google: на каждый refresh выдаёт новый access token
+// и, если rotate, новый refresh token
+type refreshServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	rotate    bool
+	expiresIn int
+	refresh   string
+	requests  int
+}
+
+func newRefreshServer(t *testing.T, rotate bool) *refreshServer {
+	// Срок меньше expiryDelta oauth2 (10 с): каждый вызов Token снова обновляет токен
+	s := &refreshServer{rotate: rotate, expiresIn: 5, refresh: "refresh-0"}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.PostForm.Get("refresh_token") != s.refresh {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		s.requests++
+		if s.rotate {
+			s.refresh = fmt.Sprintf("refresh-%d", s.requests)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token":  fmt.Sprintf("access-%d", s.requests),
+			"refresh_token": s.refresh,
+			"token_type":    "Bearer",
+			"expires_in":    s.expiresIn,
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *refreshServer) config() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Endpoint:     oauth2.Endpoint{TokenURL: s.URL, AuthStyle: oauth2.AuthStyleInParams},
+	}
+}
+
+// expiredToken токен, который oauth2 обновит при первом вызове Token
+func expiredToken() *oauth2.Token {
+	return &oauth2.Token{AccessToken: "access-old", RefreshToken: "refresh-0", Expiry: time.Now().Add(-time.Hour)}
+}
+
+// failingTokenStore хранилище, в которое не удаётся сохранить токен
+type failingTokenStore struct {
+	memoryTokenStore
+	attempts int
+}
+
+func (s *failingTokenStore) Save(*oauth2.Token) error {
+	s.attempts++
+	return errors.New("диск только для чтения")
+}
+
+func TestPersistingTokenSourceSavesRotatedToken(t *testing.T) {
+	server := newRefreshServer(t, true)
+	store := &memoryTokenStore{}
+	ts := newPersistingTokenSource(server.config().TokenSource(context.Background(), expiredToken()), store, expiredToken(), slog.Default())
+
+	for i := 1; i <= 2; i++ {
+		token, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Сохранён именно новый refresh token, старый сервер уже не примет
+		want := fmt.Sprintf("refresh-%d", i)
+		if token.RefreshToken != want || store.token == nil || store.token.RefreshToken != want || store.saves != i {
+			t.Fatalf("обновление %d: токен %v, сохранён %v, сохранений %d", i, token, store.token, store.saves)
+		}
+	}
+}
+
+func TestPersistingTokenSourceSkipsUnchangedToken(t *testing.T) {
+	server := newRefreshServer(t, false)
+	store := &memoryTokenStore{}
+	token := &oauth2.Token{AccessToken: "access-valid", RefreshToken: "refresh-0", Expiry: time.Now().Add(time.Hour)}
+	ts := newPersistingTokenSource(server.config().TokenSource(context.Background(), token), store, token, slog.Default())
+
+	for i := 0; i < 3; i++ {
+		if _, err := ts.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.saves != 0 || server.requests != 0 {
+		t.Errorf("действующий токен: сохранений %d, запросов обновления %d", store.saves, server.requests)
+	}
+}
+
+func TestPersistingTokenSourceSaveFailure(t *testing.T) {
+	server := newRefreshServer(t, false)
+	server.expiresIn = 3600
+	store := &failingTokenStore{}
+	ts := newPersistingTokenSource(server.config().TokenSource(context.Background(), expiredToken()), store, expiredToken(), slog.Default())
+
+	// Обновлённый токен возвращается, хотя сохранить его не удалось
+	for i := 0; i < 3; i++ {
+		got, err := ts.Token()
+		if err != nil || got.AccessToken != "access-1" {
+			t.Fatalf("токен %v, %v", got, err)
+		}
+	}
+	// Тот же токен не сохраняется повторно на каждый вызов Token
+	if store.attempts != 1 || server.requests != 1 {
+		t.Errorf("попыток сохранения %d, обновлений токена %d, ожидалось по одному", store.attempts, server.requests)
+	}
+}
+
+func TestOAuthClientRefreshesAndSavesToken(t *testing.T) {
+	server := newRefreshServer(t, true)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	gd, store := newAuthDisk("1", AuthFlowLoopback)
+	client := gd.oauthClient(context.Background(), server.config(), expiredToken())
+	resp, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("запрос с обновлённым токеном: %s", resp.Status)
+	}
+	if store.token == nil || store.token.RefreshToken != "refresh-1" {
+		t.Errorf("сохранён токен %v", store.token)
+	}
+}
+
+func TestNewGoogleDiskTokenStore(t *testing.T) {
+	server := newRefreshServer(t, false)
+	api := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(api.Close)
+	get := func(gd *GoogleDisk) {
+		t.Helper()
+		resp, err := gd.oauthClient(context.Background(), server.config(), expiredToken()).Get(api.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	// Диск без NewDriveService сохраняет токен в хранилище из конфигурации
+	tokenFile := filepath.Join(t.TempDir(), "token.json")
+	gd := NewGoogleDisk(&ConfigGoogleDrive{Id: "1", TokenStore: TokenStoreFile, TokenFile: tokenFile}, NewMemoryBackend(0))
+	get(gd)
+	if token, err := (&FileTokenStore{Path: tokenFile}).Load(); err != nil || token.AccessToken != "access-1" {
+		t.Errorf("сохранён токен %v, %v", token, err)
+	}
+
+	// Без хранилища токен не загружается и не сохраняется, но диск работает
+	gd.SetTokenStore(nil)
+	if token, ok := gd.loadToken(server.config()); ok {
+		t.Errorf("загружен токен %v без хранилища", token)
+	}
+	get(gd)
+}
@@ -0,0 +1,159 @@
+package googleupload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+// Хранилища токенов для ConfigGoogleDrive.TokenStore
+const (
+	// TokenStoreEncryptedFile - файл, зашифрованный текущей схемой защиты секретов (по умолчанию)
+	TokenStoreEncryptedFile = "encrypted_file"
+	// TokenStoreFile - незашифрованный JSON файл, например секрет, подключённый в контейнер
+	TokenStoreFile = "file"
+	// TokenStoreKeyring - системное хранилище ключей: Secret Service (freedesktop), Keychain, Windows Credential Manager
+	TokenStoreKeyring = "keyring"
+
+	// keyringService - имя сервиса для записей в системном хранилище ключей
+	keyringService = "google-drive-upload"
+)
+
+// ErrTokenNotFound - токен в хранилище отсутствует
+var ErrTokenNotFound = errors.New("токен не найден")
+
+// TokenStore хранилище OAuth токена диска
+type TokenStore interface {
+	// Load загружает токен, если токена нет - возвращает ошибку
+	Load() (*oauth2.Token, error)
+	// Save сохраняет токен
+	Save(token *oauth2.Token) error
+}
+
+// TokenStoreFactory создаёт хранилище токена для диска
+type TokenStoreFactory func(cfg *ConfigGoogleDrive) (TokenStore, error)
+
+var (
+	tokenStoresMu sync.RWMutex
+	tokenStores   = map[string]TokenStoreFactory{
+		TokenStoreEncryptedFile: func(cfg *ConfigGoogleDrive) (TokenStore, error) {
+			return &EncryptedFileTokenStore{Path: cfg.tokenFile()}, nil
+		},
+		TokenStoreFile: func(cfg *ConfigGoogleDrive) (TokenStore, error) {
+			return &FileTokenStore{Path: cfg.tokenFile()}, nil
+		},
+		TokenStoreKeyring: func(cfg *ConfigGoogleDrive) (TokenStore, error) {
+			return &KeyringTokenStore{Service: keyringService, Account: cfg.Id}, nil
+		},
+	}
+)
+
+// RegisterTokenStore регистрирует своё хранилище токенов (например Vault) под именем kind
+// для использования в token_store конфигурации диска
+func RegisterTokenStore(kind string, factory TokenStoreFactory) {
+	tokenStoresMu.Lock()
+	defer tokenStoresMu.Unlock()
+
+	tokenStores[kind] = factory
+}
+
+// NewTokenStore создаёт хранилище токена, указанное в конфигурации диска
+func NewTokenStore(cfg *ConfigGoogleDrive) (TokenStore, error) {
+	kind := cfg.TokenStore
+	if kind == "" {
+		kind = TokenStoreEncryptedFile
+	}
+
+	tokenStoresMu.RLock()
+	factory, ok := tokenStores[kind]
+	tokenStoresMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("неизвестное хранилище токенов token_store: %s", kind)
+	}
+	return factory(cfg)
+}
+
+// tokenFile возвращает путь к файлу токена: TokenFile или <GoogleCredentialsFile без расширения>_token.json
+func (c *ConfigGoogleDrive) tokenFile() string {
+	if c.TokenFile != "" {
+		return c.TokenFile
+	}
+	return strings.TrimSuffix(c.GoogleCredentialsFile, filepath.Ext(c.GoogleCredentialsFile)) + "_token.json"
+}
+
+// EncryptedFileTokenStore токен в файле, зашифрованном текущей схемой защиты секретов
+type EncryptedFileTokenStore struct {
+	Path string
+}
+
+func (s *EncryptedFileTokenStore) Load() (*oauth2.Token, error) {
+	return LoadToken(s.Path)
+}
+
+func (s *EncryptedFileTokenStore) Save(token *oauth2.Token) error {
+	return SaveToken(s.Path, token)
+}
+
+// FileTokenStore токен в незашифрованном JSON файле
+type FileTokenStore struct {
+	Path string
+}
+
+func (s *FileTokenStore) Load() (*oauth2.Token, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalToken(data)
+}
+
+func (s *FileTokenStore) Save(token *oauth2.Token) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.Path, data, 0600)
+}
+
+// KeyringTokenStore токен в системном хранилище ключей, на Linux - через freedesktop Secret Service
+type KeyringTokenStore struct {
+	Service string
+	Account string
+}
+
+func (s *KeyringTokenStore) Load() (*oauth2.Token, error) {
+	secret, err := keyring.Get(s.Service, s.Account)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения токена из хранилища ключей: %w", err)
+	}
+	return unmarshalToken([]byte(secret))
+}
+
+func (s *KeyringTokenStore) Save(token *oauth2.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err := keyring.Set(s.Service, s.Account, string(data)); err != nil {
+		return fmt.Errorf("ошибка записи токена в хранилище ключей: %w", err)
+	}
+	return nil
+}
+
+func unmarshalToken(data []byte) (*oauth2.Token, error) {
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
@@ -20,102 +20,255 @@ type progressReader struct {
 	reader        io.Reader
 	fileSize      int64
 	uploadedBytes atomic.Int64
-	l             *slog.Logger
+	hashes        *checksummer // nil - контрольные суммы не считаются
+	onProgress    ProgressFunc // nil - без уведомлений о прогрессе
 }
 
 // Read реализует io.Reader с подсчётом прочитанных байт
 func (pr *progressReader) Read(p []byte) (int, error) {
 	n, err := pr.reader.Read(p)
-	pr.uploadedBytes.Add(int64(n)) // атомарный инкремент
+	uploaded := pr.uploadedBytes.Add(int64(n)) // атомарный инкремент
+	if pr.hashes != nil {
+		_, _ = pr.hashes.Write(p[:n])
+	}
+	if pr.onProgress != nil && n > 0 {
+		pr.onProgress(uploaded, pr.fileSize)
+	}
 	return n, err
 }
 
+// setUploaded устанавливает количество отправленных байт при загрузке по частям
+func (pr *progressReader) setUploaded(uploaded int64) {
+	pr.uploadedBytes.Store(uploaded)
+	if pr.onProgress != nil {
+		pr.onProgress(uploaded, pr.fileSize)
+	}
+}
+
 // Progress возвращает текущий прогресс в байтах
 func (pr *progressReader) Progress() int64 {
 	return pr.uploadedBytes.Load() // атомарное чтение
 }
 
+// hashUpTo досчитывает контрольные суммы по файлу до offset, если они считаются.
+// Используется при загрузке по частям, где данные читаются через ReadAt, а не Read
+func (pr *progressReader) hashUpTo(r io.ReaderAt, offset int64) error {
+	if pr.hashes == nil {
+		return nil
+	}
+	return pr.hashes.catchUp(r, offset)
+}
+
 // UploadFile upload file to Google Drive
-// example googleupload.UploadFile(ctx, "test.zip, UseIDDisk("1"))
-func (gds *GoogleDisks) UploadFile(ctx context.Context, filename string, idDisk string) error {
-	l := slog.With("file", filename, "idDisk", idDisk)
-	gd, err := gds.findGDById(idDisk)
+// example googleupload.UploadFile(ctx, "test.zip", "1", UseCopiesCount(3))
+// Если idDisk пустой и задана политика выбора диска или idDisk - имя политики (first-fit, most-free, round-robin),
+// диск выбирается по политике с переходом на следующий при ошибке
+func (gds *GoogleDisks) UploadFile(ctx context.Context, filename string, idDisk string, opts ...UploadOption) error {
+	_, err := gds.Upload(ctx, filename, append([]UploadOption{UseIDDisk(idDisk)}, opts...)...)
+	return err
+}
+
+// Upload загружает файл как UploadFile и возвращает результат загрузки:
+// созданный файл, контрольные суммы, удалённые старые копии и освобождённое в корзине место.
+// Диск задаётся опцией UseIDDisk
+func (gds *GoogleDisks) Upload(ctx context.Context, filename string, opts ...UploadOption) (*UploadResult, error) {
+	o := newUploadOptions(opts)
+	if policy, ok := gds.selectPolicyFor(o.idDisk); ok {
+		return gds.uploadWithFailover(ctx, filename, policy, o)
+	}
+
+	gd, err := gds.findGDById(o.idDisk)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return gd.uploadFile(ctx, filename, o)
+}
+
+// uploadFile загружает файл на диск и проверяет его контрольные суммы
+func (gd *GoogleDisk) uploadFile(ctx context.Context, filename string, o *uploadOptions) (_ *UploadResult, err error) {
+	if gd.encryptor != nil || gd.cfg.Compression.Codec != "" {
+		return gd.uploadEncodedFile(ctx, filename, o)
+	}
+
+	l := slog.With("file", filename, "idDisk", gd.cfg.Id)
+	started := time.Now()
+
+	copiesCount, err := o.copies(gd)
+	if err != nil {
+		return nil, err
 	}
 
 	// Получаем информацию о файле
 	fileInfo, err := os.Stat(filename)
 	if err != nil {
-		return fmt.Errorf("ошибка получения информации о файле: %w", err)
+		return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
 	}
 	fileSize := fileInfo.Size()
 
-	// Удаляем самые старые копии, оставляя UploadCopiesCount - 1 копий
-	if err := gds.deleteOldCopies(ctx, filename); err != nil {
-		l.Warn("ошибка удаления старых копий", "error", err)
-		// Не прерываем процесс загрузки, если не удалось удалить старые копии
+	// События прогресса отправляются только на время этой загрузки
+	tracker := newProgressTracker(gd.cfg.Id, filename, fileSize, o.progressReporters())
+	defer func() { tracker.finish(err) }()
+
+	// Папки из UseFolderPath и шаблона имени создаются только после проверки места,
+	// чтобы на диске, куда файл не загружен, не оставались пустые папки
+	trashFreed, err := gd.prepareUpload(ctx, fileSize, o, tracker)
+	if err != nil {
+		return nil, err
 	}
 
-	// Умная очистка корзины: очищаем только если не хватает места
-	if err := gds.smartClearTrash(ctx, fileSize); err != nil {
-		return err
+	folderID, family, err := gd.uploadTarget(ctx, o, filepath.Base(filename))
+	if err != nil {
+		return nil, err
 	}
+	name := family.name
 
 	// Открываем файл для загрузки
 	file, err := os.Open(filename)
 	if err != nil {
-		return fmt.Errorf("ошибка открытия файла: %w", err)
+		return nil, fmt.Errorf("ошибка открытия файла: %w", err)
 	}
 	defer deferClose("ошибка закрытия файла", file.Close)
 
-	driveFile := &drive.File{
-		Name: filepath.Base(filename),
-	}
-	// Если FolderID указан, загружаем в папку, иначе - в корень диска
-	if gd.cfg.FolderID != "" {
-		driveFile.Parents = []string{gd.cfg.FolderID}
+	// Временные ошибки повторяются загрузкой заново, загрузка по частям продолжается с подтверждённого байта
+	var (
+		driveFile *drive.File
+		sums      *Checksums
+	)
+	err = gd.retry.Do(ctx, "upload", func() error {
+		driveFile, sums, err = gd.sendFile(ctx, file, fileSize, o.driveFile(folderID, name), filename, o, tracker)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error upload file: %w", err)
 	}
 
-	// Создаём progressReader для отслеживания прогресса загрузки
-	pr := &progressReader{
-		reader:   file,
-		fileSize: fileSize,
-		l:        l,
+	tracker.phase(PhaseVerifying)
+	if err := gd.verifyUpload(ctx, driveFile, sums); err != nil {
+		return nil, err
 	}
 
-	// Запускаем горутину для логирования прогресса раз в минуту
-	go pr.logProgress(ctx)
-
-	_, err = gd.Srv.Files.Create(driveFile).Media(pr).Context(ctx).Do()
-	if err != nil {
-		return fmt.Errorf("error upload file: %w", err)
+	tracker.phase(PhaseCleanup)
+	deleted := gd.rotateCopies(ctx, folderID, family, copiesCount, driveFile.Id)
+
+	result := &UploadResult{
+		DiskID:        gd.cfg.Id,
+		FileID:        driveFile.Id,
+		Name:          driveFile.Name,
+		WebViewLink:   driveFile.WebViewLink,
+		Bytes:         fileSize,
+		Checksums:     sums,
+		Duration:      time.Since(started),
+		DeletedCopies: deleted,
+		TrashFreed:    trashFreed,
 	}
 
 	l.Info("Success upload file",
 		"fileSize", FormatBytes(fileSize),
+		"fileId", result.FileID,
+		"md5", sums.MD5,
+		"duration", result.Duration,
 		slog.String("url", gd.GetUrlFile()),
 	)
 
-	return nil
+	return result, nil
+}
+
+// uploadEncodedFile загружает файл со сжатием или шифрованием. Сжатые и зашифрованные данные нельзя воспроизвести
+// с произвольного места, поэтому файл отправляется потоком, как UploadReader, без продолжения после перезапуска
+func (gd *GoogleDisk) uploadEncodedFile(ctx context.Context, filename string, o *uploadOptions) (*UploadResult, error) {
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия файла: %w", err)
+	}
+	defer deferClose("ошибка закрытия файла", file.Close)
+
+	streamOpts := *o
+	streamOpts.sizeHint = fileInfo.Size()
+	return gd.uploadReader(ctx, file, filepath.Base(filename), &streamOpts)
 }
 
-func (pr *progressReader) logProgress(ctx context.Context) {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			uploaded := pr.Progress()
-			percent := float64(uploaded) / float64(pr.fileSize) * 100
-			pr.l.Info("logProgress upload",
-				"logProgress", fmt.Sprintf("%.2f%%", percent),
-				"total", FormatBytes(pr.fileSize),
-			)
+// sendFile отправляет данные file одной попыткой и возвращает созданный файл и контрольные суммы отправленных данных
+func (gd *GoogleDisk) sendFile(ctx context.Context, file *os.File, fileSize int64, driveFile *drive.File, filename string, o *uploadOptions, tracker *progressTracker) (*drive.File, *Checksums, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+
+	// Создаём progressReader для отслеживания прогресса загрузки
+	pr := &progressReader{
+		reader:     gd.throttle(ctx, file),
+		fileSize:   fileSize,
+		hashes:     newChecksummer(gd.cfg.VerifySHA256),
+		onProgress: o.progress,
+	}
+
+	tracker.startUpload(pr)
+	defer tracker.stopUpload()
+
+	var err error
+	if gd.cfg.ResumableUpload && gd.client != nil {
+		// Загрузка по частям с сохранением состояния рядом с исходным файлом
+		uploader := &ResumableUploader{
+			Client:       gd.client,
+			ChunkSize:    o.chunkSizeFor(gd),
+			RateLimiters: []*RateLimiter{gd.rateLimit, gd.globalRateLimit},
 		}
+		driveFile, err = uploader.Upload(ctx, driveFile, file, gd.cfg.Id, StateFileName(filename, gd.cfg.Id), pr)
+	} else {
+		var mediaOpts []googleapi.MediaOption
+		if o.chunkSize > 0 {
+			mediaOpts = append(mediaOpts, googleapi.ChunkSize(int(o.chunkSize)))
+		}
+		driveFile, err = gd.backend.CreateFile(ctx, driveFile, pr, mediaOpts...)
 	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return driveFile, pr.hashes.Sum(), nil
+}
+
+// prepareUpload проверяет, что на выбранном диске хватает места для файла размером fileSize,
+// при нехватке очищает корзину. Старые копии на этом этапе не удаляются: их удаляет rotateCopies
+// после успешной загрузки, чтобы при ошибке или переходе на другой диск копии остались.
+// Возвращает освобождённое в корзине место
+func (gd *GoogleDisk) prepareUpload(ctx context.Context, fileSize int64, o *uploadOptions, tracker *progressTracker) (int64, error) {
+	if o.skipQuotaCheck {
+		return 0, nil
+	}
+
+	tracker.phase(PhaseQuotaCheck)
+	if o.skipTrashCleanup {
+		return 0, gd.checkSpace(ctx, fileSize)
+	}
+
+	// Умная очистка корзины: очищаем только если не хватает места
+	return gd.smartClearTrash(ctx, fileSize)
+}
+
+// rotateCopies после загрузки новой копии newID удаляет самые старые копии файла в папке folderID,
+// оставляя copiesCount копий вместе с новой. Ошибка удаления не прерывает загрузку. Возвращает удалённые копии
+func (gd *GoogleDisk) rotateCopies(ctx context.Context, folderID string, family copyFamily, copiesCount int, newID string) []DeletedCopy {
+	deleted, err := gd.deleteOldCopies(ctx, folderID, family, copiesCount, newID)
+	if err != nil {
+		slog.Warn("ошибка удаления старых копий", "file", family.name, "idDisk", gd.cfg.Id, "error", err)
+	}
+	return deleted
+}
+
+// newDriveFile возвращает метаданные нового файла name в папке folderID
+func newDriveFile(folderID, name string) *drive.File {
+	driveFile := &drive.File{
+		Name: name,
+	}
+	// Если folderID указан, загружаем в папку, иначе - в корень диска
+	if folderID != "" {
+		driveFile.Parents = []string{folderID}
+	}
+	return driveFile
 }
 
 func (gds *GoogleDisks) findGDById(idDisk string) (*GoogleDisk, error) {
@@ -137,132 +290,147 @@ func (gds *GoogleDisks) findGDById(idDisk string) (*GoogleDisk, error) {
 }
 
 // emptyTrash очищает корзину Google Drive (безвозвратно удаляет файлы из корзины)
-// Удаляет файлы начиная со старых, пока не освободит至少 clearSize байт
-func (gds *GoogleDisks) emptyTrash(ctx context.Context, clearSize int64) error {
-	query := "'me' in owners and trashed = true"
-
-	files, err := gds.GoogleDiskDefault.Srv.Files.List().Q(query).
-		Fields("files(id, name, size, trashedTime, createdTime)").
-		OrderBy("trashedTime asc").
-		Do()
+// Удаляет файлы начиная со старых, пока не освободит至少 clearSize байт, и возвращает освобождённое место
+func (gd *GoogleDisk) emptyTrash(ctx context.Context, clearSize int64) (int64, error) {
+	l := slog.With("idDisk", gd.cfg.Id)
+	files, err := gd.backend.ListTrash(ctx)
 	if err != nil {
-		return fmt.Errorf("ошибка получения списка файлов в корзине: %w", err)
+		return 0, fmt.Errorf("ошибка получения списка файлов в корзине: %w", err)
 	}
 
-	if len(files.Files) == 0 {
-		return nil
+	if len(files) == 0 {
+		return 0, nil
 	}
 
 	var clearedSize int64
-	for _, file := range files.Files {
+	for _, file := range files {
 		// Проверяем, достаточно ли уже освобождено места
 		if clearedSize >= clearSize && clearSize > 0 {
-			slog.Info("достигнут лимит освобождаемого места", "clearedSize", clearedSize, "clearSize", clearSize)
+			l.Info("достигнут лимит освобождаемого места", "clearedSize", clearedSize, "clearSize", clearSize)
 			break
 		}
 
-		err := gds.GoogleDiskDefault.Srv.Files.Delete(file.Id).Context(ctx).Do()
+		err := gd.backend.DeleteFile(ctx, file.Id)
 		if err != nil {
-			slog.Warn("ошибка удаления файла из корзины", "filename", file.Name, "createdTime", file.CreatedTime, "error", err)
+			l.Warn("ошибка удаления файла из корзины", "filename", file.Name, "createdTime", file.CreatedTime, "error", err)
 			continue
 		}
 
-		slog.Info("файл безвозвратно удалён из корзины", "filename", file.Name, "createdTime", file.CreatedTime, "size", file.Size)
+		l.Info("файл безвозвратно удалён из корзины", "filename", file.Name, "createdTime", file.CreatedTime, "size", file.Size)
 		clearedSize += file.Size
 	}
 
-	slog.Info("очистка корзины завершена", "clearedSize", clearedSize, "clearSize", clearSize)
-	return nil
+	l.Info("очистка корзины завершена", "clearedSize", clearedSize, "clearSize", clearSize)
+	return clearedSize, nil
 }
 
-// smartClearTrash очищает корзину Google Drive только когда не хватает места для загрузки файла
-func (gds *GoogleDisks) smartClearTrash(ctx context.Context, fileSize int64) error {
+// smartClearTrash очищает корзину диска только когда не хватает места для загрузки файла.
+// Возвращает освобождённое в корзине место
+func (gd *GoogleDisk) smartClearTrash(ctx context.Context, fileSize int64) (int64, error) {
+	l := slog.With("idDisk", gd.cfg.Id)
 	// Проверяем наличие свободного места
-	hasSpace, quota, err := gds.GoogleDiskDefault.HasEnoughSpace(ctx, fileSize)
+	hasSpace, quota, err := gd.HasEnoughSpace(ctx, fileSize)
 	if err != nil {
-		return fmt.Errorf("ошибка проверки свободного места: %w", err)
+		return 0, fmt.Errorf("ошибка проверки свободного места: %w", err)
 	}
 
 	// Если места достаточно, не очищаем корзину
 	if hasSpace {
-		return nil
+		return 0, nil
 	}
 
-	slog.Warn("недостаточно места на Google Drive, пробуем очистить корзину",
+	l.Warn("недостаточно места на Google Drive, пробуем очистить корзину",
 		"required", FormatBytes(fileSize),
 		"free", FormatBytes(quota.FreeBytes),
 		"total", FormatBytes(quota.TotalBytes),
 	)
 
 	// Очищаем корзину, освобождая至少 fileSize места
-	if err := gds.emptyTrash(ctx, fileSize); err != nil {
-		slog.Warn("ошибка очистки корзины Google Disk", "error", err)
+	freed, err := gd.emptyTrash(ctx, fileSize)
+	if err != nil {
+		l.Warn("ошибка очистки корзины Google Disk", "error", err)
 		// Не прерываем процесс, пробуем проверить место снова
 	}
 
 	// Проверяем наличие свободного места после очистки корзины
-	hasSpace, quota, err = gds.GoogleDiskDefault.HasEnoughSpace(ctx, fileSize)
+	hasSpace, quota, err = gd.HasEnoughSpace(ctx, fileSize)
 	if err != nil {
-		return fmt.Errorf("ошибка проверки свободного места после очистки корзины: %w", err)
+		return 0, fmt.Errorf("ошибка проверки свободного места после очистки корзины: %w", err)
 	}
 
 	if !hasSpace {
-		return fmt.Errorf("недостаточно свободного места на Google Drive даже после очистки корзины. Требуется: %s, свободно: %s (всего: %s, используется: %s)",
+		return 0, fmt.Errorf("недостаточно свободного места на Google Drive даже после очистки корзины. Требуется: %s, свободно: %s (всего: %s, используется: %s)",
 			FormatBytes(fileSize), FormatBytes(quota.FreeBytes), FormatBytes(quota.TotalBytes), FormatBytes(quota.UsedBytes))
 	}
 
-	slog.Info("корзина очищена, места достаточно для загрузки",
+	l.Info("корзина очищена, места достаточно для загрузки",
 		"required", FormatBytes(fileSize),
 		"free", FormatBytes(quota.FreeBytes),
 	)
 
+	return freed, nil
+}
+
+// checkSpace проверяет свободное место без очистки корзины
+func (gd *GoogleDisk) checkSpace(ctx context.Context, fileSize int64) error {
+	hasSpace, quota, err := gd.HasEnoughSpace(ctx, fileSize)
+	if err != nil {
+		return fmt.Errorf("ошибка проверки свободного места: %w", err)
+	}
+	if !hasSpace {
+		return fmt.Errorf("недостаточно свободного места на Google Drive, очистка корзины отключена. Требуется: %s, свободно: %s",
+			FormatBytes(fileSize), FormatBytes(quota.FreeBytes))
+	}
 	return nil
 }
 
-// deleteOldCopies удаляет самые старые копии файла, оставляя UploadCopiesCount - 1 копий
-func (gds *GoogleDisks) deleteOldCopies(ctx context.Context, filename string) error {
-	// Получаем базовое имя файла без пути
-	basename := filepath.Base(filename)
+// deleteOldCopies удаляет самые старые копии файла в папке folderID, кроме новой копии newID,
+// оставляя copiesCount - 1 старых копий. Возвращает удалённые копии
+func (gd *GoogleDisk) deleteOldCopies(ctx context.Context, folderID string, family copyFamily, copiesCount int, newID string) ([]DeletedCopy, error) {
+	l := slog.With("idDisk", gd.cfg.Id)
 
-	// Получаем список файлов в папке с таким же именем
-	var query string
-	if gds.GoogleDiskDefault.cfg.FolderID != "" {
-		query = fmt.Sprintf("'%s' in parents and name = '%s' and trashed = false",
-			gds.GoogleDiskDefault.cfg.FolderID, basename)
-	} else {
-		// Если FolderID пустой, ищем файлы в корне диска (без родителя)
-		query = fmt.Sprintf("name = '%s' and trashed = false and 'root' in parents",
-			basename)
+	// Иначе maxCopies отрицательный и удалять пришлось бы больше копий, чем есть
+	if err := checkCopiesCount(copiesCount); err != nil {
+		return nil, err
 	}
 
-	files, err := gds.GoogleDiskDefault.Srv.Files.List().Q(query).
-		Fields("files(id, name, modifiedTime)").OrderBy("modifiedTime asc").Do()
+	// Получаем список копий файла в папке: с таким же именем или по шаблону имени
+	copies, err := gd.findCopies(ctx, folderID, family)
 	if err != nil {
-		// Если ошибка "Not found" (404), это нормально - просто нет файлов, выходим без ошибки
-		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == 404 {
-			return nil
+		return nil, fmt.Errorf("ошибка получения списка файлов: %w", err)
+	}
+	files := make([]*drive.File, 0, len(copies))
+	for _, f := range copies {
+		if f.Id != newID {
+			files = append(files, f)
 		}
-		return fmt.Errorf("ошибка получения списка файлов: %w", err)
 	}
 
-	// Если файлов меньше или равно UploadCopiesCount - 1, ничего не удаляем
-	maxCopies := gds.GoogleDiskDefault.cfg.UploadCopiesCount - 1
-	if len(files.Files) <= maxCopies {
-		return nil
+	// Если файлов меньше или равно copiesCount - 1, ничего не удаляем
+	maxCopies := copiesCount - 1
+	if len(files) <= maxCopies {
+		return nil, nil
 	}
 
 	// Удаляем самые старые файлы, оставляя только maxCopies копий
-	filesToDelete := len(files.Files) - maxCopies
+	filesToDelete := len(files) - maxCopies
+	var deleted []DeletedCopy
 	for i := 0; i < filesToDelete; i++ {
-		err := gds.GoogleDiskDefault.Srv.Files.Delete(files.Files[i].Id).Context(ctx).Do()
+		err := gd.backend.DeleteFile(ctx, files[i].Id)
 		if err != nil {
-			slog.Warn("ошибка удаления файла в google disk", "fileId", files.Files[i].Id, "filename", files.Files[i].Name, "error", err)
+			l.Warn("ошибка удаления файла в google disk", "fileId", files[i].Id, "filename", files[i].Name, "error", err)
 		} else {
-			slog.Info("удален старый файл в google disk", "filename", files.Files[i].Name, "modifiedTime", files.Files[i].ModifiedTime)
+			l.Info("удален старый файл в google disk", "filename", files[i].Name, "modifiedTime", files[i].ModifiedTime)
+			deleted = append(deleted, DeletedCopy{
+				FileID:       files[i].Id,
+				Name:         files[i].Name,
+				Size:         files[i].Size,
+				ModifiedTime: files[i].ModifiedTime,
+			})
 		}
 	}
 
-	return nil
+	return deleted, nil
 }
 
 func deferClose(msg string, fc func() error) {
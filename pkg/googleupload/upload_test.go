@@ -0,0 +1,304 @@
+package googleupload
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// spyBackend записывает вызовы методов хранилища, которые читают или изменяют файлы аккаунта
+type spyBackend struct {
+	DriveBackend
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *spyBackend) record(call string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, call)
+}
+
+func (s *spyBackend) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+func (s *spyBackend) FindFiles(ctx context.Context, folderID, name string) ([]*drive.File, error) {
+	s.record("FindFiles")
+	return s.DriveBackend.FindFiles(ctx, folderID, name)
+}
+
+func (s *spyBackend) FindFilesByPrefix(ctx context.Context, folderID, prefix string) ([]*drive.File, error) {
+	s.record("FindFilesByPrefix")
+	return s.DriveBackend.FindFilesByPrefix(ctx, folderID, prefix)
+}
+
+func (s *spyBackend) ListTrash(ctx context.Context) ([]*drive.File, error) {
+	s.record("ListTrash")
+	return s.DriveBackend.ListTrash(ctx)
+}
+
+func (s *spyBackend) DeleteFile(ctx context.Context, fileID string) error {
+	s.record("DeleteFile")
+	return s.DriveBackend.DeleteFile(ctx, fileID)
+}
+
+func (s *spyBackend) TrashFile(ctx context.Context, fileID string) error {
+	s.record("TrashFile")
+	return s.DriveBackend.TrashFile(ctx, fileID)
+}
+
+func (s *spyBackend) GetStorageQuota(ctx context.Context) (*StorageQuota, error) {
+	s.record("GetStorageQuota")
+	return s.DriveBackend.GetStorageQuota(ctx)
+}
+
+func (s *spyBackend) CreateFile(ctx context.Context, meta *drive.File, media io.Reader, opts ...googleapi.MediaOption) (*drive.File, error) {
+	s.record("CreateFile")
+	return s.DriveBackend.CreateFile(ctx, meta, media, opts...)
+}
+
+func (s *spyBackend) ListFolder(ctx context.Context, folderID string) ([]*drive.File, error) {
+	s.record("ListFolder")
+	return s.DriveBackend.ListFolder(ctx, folderID)
+}
+
+// fileNames возвращает отсортированные имена файлов хранилища, файлы в корзине помечены "trash:"
+func fileNames(m *MemoryBackend) []string {
+	var names []string
+	for _, f := range m.Files() {
+		name := f.Name
+		if f.Trashed {
+			name = "trash:" + name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestUploadTouchesOnlySelectedDisk(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "db.sql")
+	data := []byte(strings.Repeat("backup", 100))
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Оба аккаунта: старая копия db.sql и файл в корзине, места на новую копию рядом со старой
+	// без очистки корзины не хватает
+	newDisk := func(id string) (*GoogleDisk, *MemoryBackend, *spyBackend) {
+		mem := NewMemoryBackend(int64(3 * len(data)))
+		mem.AddFile("folder", "db.sql", data)
+		trashed := mem.AddFile("folder", "old.sql", data)
+		if err := mem.TrashFile(ctx, trashed.Id); err != nil {
+			t.Fatal(err)
+		}
+		mem.AddFile("folder", "other.txt", data[:10])
+		spy := &spyBackend{DriveBackend: mem}
+		return NewGoogleDisk(&ConfigGoogleDrive{Id: id, UploadCopiesCount: 1, FolderID: "folder", Enable: true}, spy), mem, spy
+	}
+	diskA, memA, spyA := newDisk("a")
+	diskB, memB, spyB := newDisk("b")
+	before := fileNames(memA)
+
+	gds, err := NewGoogleDisks(diskA, diskB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := gds.Upload(ctx, filename, UseIDDisk("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := spyA.Calls(); len(calls) != 0 {
+		t.Errorf("загрузка на диск b обращалась к диску a: %v", calls)
+	}
+	if got := fileNames(memA); strings.Join(got, ",") != strings.Join(before, ",") {
+		t.Errorf("файлы диска a изменились: %v, было %v", got, before)
+	}
+
+	// На диске b старая копия удалена, корзина очищена, новая копия загружена
+	if want := []string{"db.sql", "other.txt"}; strings.Join(fileNames(memB), ",") != strings.Join(want, ",") {
+		t.Errorf("файлы диска b: %v, ожидалось %v", fileNames(memB), want)
+	}
+	if len(result.DeletedCopies) != 1 || result.TrashFreed != int64(len(data)) {
+		t.Errorf("удалено копий %d, освобождено в корзине %d", len(result.DeletedCopies), result.TrashFreed)
+	}
+	for _, want := range []string{"FindFiles", "DeleteFile", "ListTrash", "GetStorageQuota", "CreateFile"} {
+		if !contains(spyB.Calls(), want) {
+			t.Errorf("на диске b не вызван %s: %v", want, spyB.Calls())
+		}
+	}
+
+	// Список копий выбранного диска тоже не затрагивает другой аккаунт
+	copies, err := diskB.ListCopies(ctx, "db.sql")
+	if err != nil || len(copies) != 1 || copies[0].Id != result.FileID {
+		t.Errorf("копии на диске b: %v, %v", copies, err)
+	}
+	if calls := spyA.Calls(); len(calls) != 0 {
+		t.Errorf("список копий диска b обращался к диску a: %v", calls)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUploadFileByDiskID(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "db.sql")
+	if err := os.WriteFile(filename, []byte("backup"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	memA, memB := NewMemoryBackend(1<<20), NewMemoryBackend(1<<20)
+	gds, err := NewGoogleDisks(
+		NewGoogleDisk(&ConfigGoogleDrive{Id: "a", UploadCopiesCount: 1, Enable: true}, memA),
+		NewGoogleDisk(&ConfigGoogleDrive{Id: "b", UploadCopiesCount: 1, Enable: true}, memB),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Прежняя сигнатура: диск передаётся строкой, опции - после него
+	if err := gds.UploadFile(ctx, filename, "b", UseRemoteName("renamed.sql")); err != nil {
+		t.Fatal(err)
+	}
+	if len(memA.Files()) != 0 || strings.Join(fileNames(memB), ",") != "renamed.sql" {
+		t.Errorf("файлы диска a %v, диска b %v", fileNames(memA), fileNames(memB))
+	}
+}
+
+// failCreateBackend отклоняет загрузку файлов
+type failCreateBackend struct {
+	DriveBackend
+}
+
+func (failCreateBackend) CreateFile(context.Context, *drive.File, io.Reader, ...googleapi.MediaOption) (*drive.File, error) {
+	return nil, &googleapi.Error{Code: 500, Message: "backendError"}
+}
+
+func TestFailoverKeepsCopiesOnFailedDisk(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "db.sql")
+	if err := os.WriteFile(filename, []byte("new backup"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Загрузка на диск a не удаётся: его старая копия - единственная, удалять её нельзя
+	memA, memB := NewMemoryBackend(1<<20), NewMemoryBackend(1<<20)
+	memA.AddFile("", "db.sql", []byte("old backup"))
+	memB.AddFile("", "db.sql", []byte("old backup"))
+	diskA := NewGoogleDisk(&ConfigGoogleDrive{Id: "a", UploadCopiesCount: 1, Enable: true}, failCreateBackend{memA})
+	diskB := NewGoogleDisk(&ConfigGoogleDrive{Id: "b", UploadCopiesCount: 1, Enable: true}, memB)
+	gds, err := NewGoogleDisks(diskA, diskB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gds.SelectPolicy = SelectFirstFit
+
+	result, err := gds.Upload(ctx, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := memA.Files(); len(files) != 1 || files[0].Trashed {
+		t.Errorf("копии на диске a после неудачной загрузки: %v", fileNames(memA))
+	}
+	// На диске b новая копия заменила старую
+	if files := memB.Files(); result.DiskID != "b" || len(files) != 1 || files[0].Id != result.FileID || len(result.DeletedCopies) != 1 {
+		t.Errorf("диск %s, файлы диска b: %v, удалено %d", result.DiskID, fileNames(memB), len(result.DeletedCopies))
+	}
+}
+
+func TestUploadRejectsZeroCopies(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "db.sql")
+	if err := os.WriteFile(filename, []byte("new backup"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// С нулём копий ротация удаляла бы на одну копию больше, чем есть
+	mem := NewMemoryBackend(1 << 20)
+	mem.AddFile("", "db.sql", []byte("old backup"))
+	gd := NewGoogleDisk(&ConfigGoogleDrive{Id: "d", UploadCopiesCount: 1, Enable: true}, mem)
+	gds, err := NewGoogleDisks(gd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, count := range []int{0, -1} {
+		if err := gds.UploadFile(ctx, filename, "d", UseCopiesCount(count)); err == nil {
+			t.Errorf("UseCopiesCount(%d): ожидалась ошибка", count)
+		}
+	}
+	if files := mem.Files(); len(files) != 1 || files[0].Trashed {
+		t.Errorf("файлы после отклонённых загрузок: %v", fileNames(mem))
+	}
+
+	if _, err := gd.deleteOldCopies(ctx, "", copyFamily{name: "db.sql"}, 0, ""); err == nil {
+		t.Error("deleteOldCopies с нулём копий: ожидалась ошибка")
+	}
+	if len(mem.Files()) != 1 {
+		t.Errorf("deleteOldCopies с нулём копий удалил файлы: %v", fileNames(mem))
+	}
+
+	cfg := &ConfigGoogleDrive{Id: "d", AuthFlow: AuthFlowLoopback, AuthType: AuthTypeADC}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "upload_copies_count") {
+		t.Errorf("upload_copies_count: 0 в конфигурации: %v", err)
+	}
+}
+
+func TestFailoverRanksDisksByStoredSize(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "db.sql")
+	data := make([]byte, 4<<10)
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// На диске a исходный файл помещается, а сжатый в худшем случае - нет: первым пробуется диск b
+	memA := NewMemoryBackend(int64(len(data)) + 10)
+	spyA := &spyBackend{DriveBackend: memA}
+	diskA := NewGoogleDisk(&ConfigGoogleDrive{Id: "a", UploadCopiesCount: 1, Enable: true, Compression: ConfigCompression{Codec: CodecGzip}}, spyA)
+	diskB := NewGoogleDisk(&ConfigGoogleDrive{Id: "b", UploadCopiesCount: 1, Enable: true}, NewMemoryBackend(1<<20))
+	gds, err := NewGoogleDisks(diskA, diskB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gds.SelectPolicy = SelectFirstFit
+
+	result, err := gds.Upload(ctx, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DiskID != "b" || strings.Join(spyA.Calls(), ",") != "GetStorageQuota" {
+		t.Errorf("загружено на диск %s, вызовы диска a: %v", result.DiskID, spyA.Calls())
+	}
+
+	// Ошибка чтения файла возвращается до выбора диска
+	missing := filepath.Join(t.TempDir(), "missing.sql")
+	if _, err := gds.Upload(ctx, missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("загрузка несуществующего файла: %v", err)
+	}
+	if calls := spyA.Calls(); len(calls) != 1 {
+		t.Errorf("для несуществующего файла запрошены диски: %v", calls)
+	}
+}